@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/lift-plugins/auth/openidc/tokens"
+)
+
+// Can returns whether the current session was granted all the given scopes. Wildcard and hierarchical
+// scopes are supported, as described in tokens.JSONWebToken.HasScope.
+func Can(scopes ...string) (bool, error) {
+	return NewClient().Can(context.Background(), scopes...)
+}
+
+// Can returns whether the session was granted all the given scopes, refreshing tokens first if they expired.
+func (c *Client) Can(ctx context.Context, scopes ...string) (bool, error) {
+	tks, err := c.validTokens(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	accessToken, err := tokens.Decode(tks.Access)
+	if err != nil {
+		return false, errors.Wrap(err, "scopes of the access token are unknown")
+	}
+
+	return accessToken.HasScopes(scopes...), nil
+}
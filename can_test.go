@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+)
+
+func TestCan(t *testing.T) {
+	mem := newMemStore()
+	serviceSession(t, mem, "http://127.0.0.1:1")
+
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"scope": ["apps", "deploy"]}`))
+	writeAccess(t, mem, "e30."+payload+".sig")
+
+	c := NewClient(WithStore(mem))
+	if allowed, err := c.Can(context.Background(), "deploy", "apps:web"); err != nil || !allowed {
+		t.Errorf("expected granted scopes to be allowed, got %t, %v", allowed, err)
+	}
+
+	if allowed, err := c.Can(context.Background(), "admin"); err != nil || allowed {
+		t.Errorf("expected scopes not granted to be denied, got %t, %v", allowed, err)
+	}
+
+	// Scopes of opaque tokens cannot be told, which is not the same as being denied.
+	writeAccess(t, mem, "opaque")
+	if _, err := c.Can(context.Background(), "deploy"); err == nil {
+		t.Error("expected an error for opaque access tokens")
+	}
+}
@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/lift-plugins/auth/openidc/clients"
+	"github.com/lift-plugins/auth/openidc/discovery"
+	"github.com/lift-plugins/auth/openidc/grpcutil"
+	"github.com/lift-plugins/auth/openidc/oauth2"
+	"github.com/lift-plugins/auth/openidc/profiles"
+	"github.com/lift-plugins/auth/openidc/store"
+	"github.com/lift-plugins/auth/openidc/tokens"
+	"google.golang.org/grpc"
+)
+
+// DefaultProvider is the identity provider used when none is set with WithProvider.
+const DefaultProvider = "https://id.hooklift.io:443"
+
+// Client manages a Lift session with an identity provider. Its methods accept a context to cancel
+// or set deadlines on requests to the provider. The zero value is not usable, use NewClient.
+//
+// Clients do not change package level configuration, so that clients for different profiles, stores
+// or HTTP clients can be used concurrently.
+type Client struct {
+	provider   string
+	profile    string
+	httpClient *http.Client
+	store      store.Store
+	scopes     []string
+	audiences  []string
+
+	// maxAge and maxIssuedAge configure validation of ID tokens received when signing in.
+	maxAge       time.Duration
+	maxIssuedAge time.Duration
+
+	// discoveryOptions configure fetches of provider configuration and signing keys.
+	discoveryOptions discovery.Options
+
+	// grantedScopesHook is called when the provider grants scopes different from the requested ones.
+	grantedScopesHook func(requested, granted []string)
+
+	// isIdempotent and signInRequired configure connections returned by Dial.
+	isIdempotent   func(method string) bool
+	signInRequired func(err error) error
+
+	// err is the configuration error returned by all methods, such as an invalid profile name.
+	err error
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithProvider sets the identity provider address used to sign in. Defaults to DefaultProvider, or
+// to the issuer of the cached provider configuration when refreshing it.
+func WithProvider(address string) Option {
+	return func(c *Client) {
+		c.provider = address
+	}
+}
+
+// WithProfile sets the profile whose session is managed. Defaults to the active profile.
+func WithProfile(name string) Option {
+	return func(c *Client) {
+		c.profile = name
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for requests to the identity provider.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = client
+	}
+}
+
+// WithScopes sets the scopes requested when signing in. Defaults to the standard scopes
+// supported by the provider.
+func WithScopes(scope ...string) Option {
+	return func(c *Client) {
+		c.scopes = scope
+	}
+}
+
+// WithAudiences sets the audiences requested when signing in. Defaults to Hooklift services.
+func WithAudiences(audience ...string) Option {
+	return func(c *Client) {
+		c.audiences = audience
+	}
+}
+
+// WithMaxAge sets the maximum time since the user last authenticated with the provider when signing
+// in through the browser. It is sent as max_age, making the provider ask users to authenticate again
+// past it, and ID tokens are required to have an auth_time claim no older than it.
+func WithMaxAge(d time.Duration) Option {
+	return func(c *Client) {
+		c.maxAge = d
+	}
+}
+
+// WithMaxIssuedAge sets how far in the past the iat claim of ID tokens received when signing in is
+// accepted. Defaults to 10 minutes.
+func WithMaxIssuedAge(d time.Duration) Option {
+	return func(c *Client) {
+		c.maxIssuedAge = d
+	}
+}
+
+// WithInvalidKeyHook sets the function called for every invalid key found in the provider's key
+// set, before skipping it. Programs can use it to send security telemetry. By default, invalid keys
+// are only reported when debugging.
+func WithInvalidKeyHook(hook func(jwkURI, kid string, err error)) Option {
+	return func(c *Client) {
+		c.discoveryOptions.InvalidKeyHook = hook
+	}
+}
+
+// WithCacheTTL bounds how long provider configuration and signing keys are cached, whatever the
+// provider advertises. Defaults to between 5 minutes and 24 hours.
+func WithCacheTTL(min, max time.Duration) Option {
+	return func(c *Client) {
+		c.discoveryOptions.MinCacheTTL = min
+		c.discoveryOptions.MaxCacheTTL = max
+	}
+}
+
+// WithGrantedScopesHook sets the function called when the provider grants scopes different from the
+// ones requested when signing in. By default, a warning is displayed.
+func WithGrantedScopesHook(hook func(requested, granted []string)) Option {
+	return func(c *Client) {
+		c.grantedScopesHook = hook
+	}
+}
+
+// WithStore sets where tokens, client data and provider configuration are persisted. Defaults to
+// the store selected through LIFT_AUTH_STORE. It takes precedence over WithProfile.
+func WithStore(s store.Store) Option {
+	return func(c *Client) {
+		c.store = s
+	}
+}
+
+// WithIdempotent sets the function reporting whether a gRPC method, in "/package.Service/Method"
+// form, can be retried by connections returned by Dial after the server rejected the session tokens.
+// No method is retried by default, see grpcutil.WithIdempotent.
+func WithIdempotent(isIdempotent func(method string) bool) Option {
+	return func(c *Client) {
+		c.isIdempotent = isIdempotent
+	}
+}
+
+// WithSignInRequiredHook sets the function called by connections returned by Dial when the session
+// tokens are rejected even after refreshing them, see grpcutil.WithSignInRequired.
+func WithSignInRequiredHook(hook func(err error) error) Option {
+	return func(c *Client) {
+		c.signInRequired = hook
+	}
+}
+
+// NewClient returns a Client configured with the given options.
+func NewClient(options ...Option) *Client {
+	c := new(Client)
+	for _, option := range options {
+		option(c)
+	}
+
+	if c.store == nil {
+		c.store = store.Default
+		if c.profile != "" {
+			if c.err = profiles.Validate(c.profile); c.err == nil {
+				c.store = store.New(c.profile)
+			}
+		}
+	}
+	return c
+}
+
+// providerAddress returns the identity provider address set with WithProvider, or DefaultProvider.
+func (c *Client) providerAddress() string {
+	if c.provider == "" {
+		return DefaultProvider
+	}
+	return c.provider
+}
+
+// bind returns ctx carrying the HTTP client used for requests to the provider and the options of
+// discovery fetches, or the error found while configuring c.
+func (c *Client) bind(ctx context.Context) (context.Context, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	if c.httpClient != nil {
+		ctx = oauth2.NewContext(ctx, c.httpClient)
+	}
+	return discovery.NewContext(ctx, c.discoveryOptions), nil
+}
+
+// verifyOptions returns how ID tokens received when signing in are validated. maxAge tells whether
+// the max age set with WithMaxAge was sent in the authentication request.
+func (c *Client) verifyOptions(maxAge bool) []tokens.VerifyOption {
+	var options []tokens.VerifyOption
+	if c.maxIssuedAge > 0 {
+		options = append(options, tokens.WithMaxIssuedAge(c.maxIssuedAge))
+	}
+
+	if maxAge && c.maxAge > 0 {
+		options = append(options, tokens.WithMaxAge(c.maxAge))
+	}
+	return options
+}
+
+// Token returns a valid access token for the current session, refreshing tokens if they expired.
+func (c *Client) Token(ctx context.Context) (string, error) {
+	tks, err := c.validTokens(ctx)
+	if err != nil {
+		return "", err
+	}
+	return tks.Access, nil
+}
+
+// validTokens returns the session tokens, refreshing them first if they expired.
+func (c *Client) validTokens(ctx context.Context) (*tokens.Tokens, error) {
+	ctx, err := c.bind(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tks, client, err := c.session()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tks.RefreshToken(ctx, c.store, client.ClientId, client.ClientSecret); err != nil {
+		return nil, err
+	}
+	return tks, nil
+}
+
+// Refresh gets new tokens from the provider, even if current ones did not expire yet.
+func (c *Client) Refresh(ctx context.Context) error {
+	ctx, err := c.bind(ctx)
+	if err != nil {
+		return err
+	}
+
+	tks, client, err := c.session()
+	if err != nil {
+		return err
+	}
+
+	return tks.ForceRefresh(ctx, c.store, client.ClientId, client.ClientSecret)
+}
+
+// Dial returns a connection to the gRPC service at address, authenticated with the session tokens.
+// Tokens are refreshed when they expire or the service rejects them.
+func (c *Client) Dial(ctx context.Context, address, userAgent string) (*grpc.ClientConn, error) {
+	ctx, err := c.bind(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var options []grpcutil.Option
+	if c.isIdempotent != nil {
+		options = append(options, grpcutil.WithIdempotent(c.isIdempotent))
+	}
+
+	if c.signInRequired != nil {
+		options = append(options, grpcutil.WithSignInRequired(c.signInRequired))
+	}
+	return grpcutil.Dial(ctx, c.store, address, userAgent, options...)
+}
+
+// session loads the stored tokens and OpenIDC client.
+func (c *Client) session() (*tokens.Tokens, *clients.Client, error) {
+	tks := new(tokens.Tokens)
+	if err := tks.Read(c.store); err != nil {
+		return nil, nil, err
+	}
+
+	client, err := clients.Load(c.store, tks.ServiceIdentity)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tks, client, nil
+}
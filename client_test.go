@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lift-plugins/auth/openidc/clients"
+	"github.com/lift-plugins/auth/openidc/discovery"
+	"github.com/lift-plugins/auth/openidc/store"
+	"github.com/lift-plugins/auth/openidc/tokens"
+)
+
+// memStore keeps documents in memory, encoded as JSON like the file store does.
+type memStore struct {
+	mu   sync.Mutex
+	docs map[string][]byte
+}
+
+func newMemStore() *memStore {
+	return &memStore{docs: make(map[string][]byte)}
+}
+
+func (m *memStore) Read(name string, v interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, ok := m.docs[name]
+	if !ok {
+		return os.ErrNotExist
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (m *memStore) Write(name string, v interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	m.docs[name] = data
+	return nil
+}
+
+func (m *memStore) Delete(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.docs[name]; !ok {
+		return os.ErrNotExist
+	}
+	delete(m.docs, name)
+	return nil
+}
+
+// serviceSession stores an expired service identity session in s, whose tokens are requested
+// from tokenEndpoint.
+func serviceSession(t *testing.T, s store.Store, tokenEndpoint string) {
+	config := &discovery.ProviderConfig{Issuer: "https://id.example.com", TokenEndpoint: tokenEndpoint}
+	if err := config.Write(s); err != nil {
+		t.Fatal(err)
+	}
+
+	client := new(clients.Client)
+	client.ClientId = "ci"
+	client.ClientSecret = "secret"
+	if err := client.WriteService(s); err != nil {
+		t.Fatal(err)
+	}
+
+	tks := &tokens.Tokens{
+		Issuer:          config.Issuer,
+		Access:          "expired",
+		ServiceIdentity: true,
+		ExpiresAt:       time.Now().Add(-time.Hour).Unix(),
+	}
+	if err := tks.Write(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewClientOptions(t *testing.T) {
+	c := NewClient()
+	if c.providerAddress() != DefaultProvider {
+		t.Errorf("expected default provider, got %q", c.providerAddress())
+	}
+
+	if c.store != store.Default {
+		t.Error("expected default store")
+	}
+
+	mem := newMemStore()
+	c = NewClient(WithStore(mem), WithProfile("work"), WithProvider("https://id.example.com"))
+	if c.store != mem {
+		t.Error("WithStore should take precedence over WithProfile")
+	}
+
+	if c.providerAddress() != "https://id.example.com" {
+		t.Errorf("expected provider to be set, got %q", c.providerAddress())
+	}
+
+	if os.Getenv("LIFT_AUTH_STORE") == "" {
+		c = NewClient(WithProfile("work"))
+		if f, ok := c.store.(*store.File); !ok || f.Profile != "work" {
+			t.Errorf("expected a file store for profile work, got %#v", c.store)
+		}
+	}
+
+	c = NewClient(WithProfile("../escape"))
+	if _, err := c.Token(context.Background()); err == nil {
+		t.Error("expected an error for an invalid profile")
+	}
+
+	// max_age is only validated by flows sending it.
+	c = NewClient(WithMaxAge(time.Hour), WithMaxIssuedAge(time.Minute))
+	if options := c.verifyOptions(true); len(options) != 2 {
+		t.Errorf("expected max age and max issued age to be validated, got %d options", len(options))
+	}
+
+	if options := c.verifyOptions(false); len(options) != 1 {
+		t.Errorf("expected only max issued age to be validated, got %d options", len(options))
+	}
+}
+
+func TestClientUsesStoreAndHTTPClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _, _ := r.BasicAuth(); user != "ci" {
+			t.Errorf("expected client credentials of the stored client, got %q", user)
+		}
+		fmt.Fprint(w, `{"access_token": "fresh", "token_type": "Bearer", "expires_in": 3600}`)
+	}))
+	defer srv.Close()
+
+	mem := newMemStore()
+	serviceSession(t, mem, srv.URL)
+
+	var requests int
+	httpClient := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+
+	c := NewClient(WithStore(mem), WithHTTPClient(httpClient))
+	token, err := c.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "fresh" {
+		t.Errorf("expected refreshed token, got %q", token)
+	}
+
+	if requests != 1 {
+		t.Errorf("expected 1 request through the configured HTTP client, got %d", requests)
+	}
+
+	stored := new(tokens.Tokens)
+	if err := stored.Read(mem); err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.Access != "fresh" {
+		t.Errorf("expected refreshed token to be written to the configured store, got %q", stored.Access)
+	}
+}
+
+func TestClientCancel(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	mem := newMemStore()
+	serviceSession(t, mem, srv.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := NewClient(WithStore(mem)).Token(ctx)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected an error once the context is canceled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request to the provider was not canceled along with the context")
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
+	"time"
 
 	docopt "github.com/docopt/docopt-go"
 
@@ -20,24 +23,68 @@ const usage = `
 Manages identity and authorization against Hooklift's Identity system.
 
 Usage:
-  auth login [--provider=ADDRESS:PORT]
-  auth logout
-  auth whoami
-  auth tokens
+  auth login [--provider=ADDRESS:PORT | --email=EMAIL] [--profile=NAME] [--device | --browser] [--scope=SCOPE...] [--audience=AUDIENCE...]
+  auth login [--provider=ADDRESS:PORT] [--profile=NAME] --client-credentials [--client-id=ID] [--client-secret=SECRET | --client-secret-file=FILE] [--scope=SCOPE...] [--audience=AUDIENCE...]
+  auth logout [--profile=NAME] [--strict] [--verbose] [--global]
+  auth whoami [--profile=NAME] [--full]
+  auth tokens [--profile=NAME]
+  auth tokens inspect [--profile=NAME] [--remote]
+  auth token --audience=AUDIENCE [--scope=SCOPE...] [--profile=NAME]
+  auth can <scope>... [--profile=NAME]
+  auth discovery refresh [--provider=ADDRESS:PORT] [--profile=NAME] [--force]
+  auth profiles list
+  auth profiles use <name>
+  auth profiles delete <name>
   auth -h | --help
   auth -v | --verbose
   auth --version
 
 Commands:
   login                                    Signs you into Hooklift.
-  logout                                   Clears locally stored tokens.
+  logout                                   Revokes tokens and clears them from local storage.
   whoami                                   Displays currently signed user.
   tokens                                   Shows ID and Access tokens.
+  tokens inspect                           Shows decoded token claims, or whether the provider considers them active.
+  token                                    Shows an access token restricted to a single audience and scopes.
+  can                                      Exits successfully if you were granted all the given scopes, with 1 if
+                                          you were not, or with 2 if it cannot be told, such as for opaque tokens.
+  discovery refresh                        Revalidates cached identity provider configuration and keys.
+  profiles list                            Lists profiles, marking the active one.
+  profiles use                             Sets the profile to use by default.
+  profiles delete                          Deletes all data stored for a profile.
 
 Options:
-  -p --provider=ADDRESS:PORT              The identity provider address. [default: https://id.hooklift.io:443]
+  -p --provider=ADDRESS:PORT              The identity provider address. Defaults to https://id.hooklift.io:443,
+                                          or to the cached provider when refreshing discovery.
+  --email=EMAIL                           Finds the identity provider of your email address, using WebFinger.
+  --force                                 Ignores cached copies when refreshing provider configuration.
+  --profile=NAME                          The profile to use. Defaults to $LIFT_AUTH_PROFILE or the one set with "profiles use".
+  --device                                Signs in by approving a code from another device's browser.
+  --browser                               Signs in through your web browser.
+  --client-credentials                    Signs in a service identity, such as a CI pipeline, without prompting.
+  --client-id=ID                          Client ID of the service identity. Defaults to $LIFT_CLIENT_ID.
+  --client-secret=SECRET                  Client secret of the service identity. Defaults to $LIFT_CLIENT_SECRET.
+  --client-secret-file=FILE               File containing the client secret. Defaults to $LIFT_CLIENT_SECRET_FILE.
+  --strict                                Fails signing out, keeping tokens, if they could not be revoked.
+  --verbose                               Shows the result of revoking each token.
+  --global                                Also signs you out from the identity provider in your web browser.
+  --full                                  Shows details about the session, as reported by the identity provider.
+  --remote                                Inspects tokens using the identity provider's introspection endpoint.
+  --audience=AUDIENCE                     A service the access token is intended for. Can be repeated when signing in,
+                                          defaults to Hooklift services.
+  --scope=SCOPE                           A scope to request. Can be repeated. When signing in, defaults to the
+                                          standard scopes supported by the identity provider.
   -h --help                               Shows this screen.
   -v --version                            Shows version of this plugin.
+
+Environment:
+  LIFT_AUTH_PROFILE                       The profile to use when --profile is not set.
+  LIFT_AUTH_STORE                         Set to "encrypted" to seal refresh tokens and client secrets at rest, or
+                                          to "keyring" to keep them in the desktop keyring (Linux only).
+  LIFT_AUTH_PASSPHRASE                    Passphrase used by the encrypted store.
+  LIFT_AUTH_KEY_FILE                      File whose contents are used as passphrase by the encrypted store.
+  LIFT_AUTH_DEV                           Set to 1 to accept the lenient configuration of a local development
+                                          identity provider. Its certificate is only trusted by dev builds.
 `
 
 func main() {
@@ -58,6 +105,27 @@ func main() {
 		return
 	}
 
+	if profile, ok := args["--profile"].(string); ok && profile != "" {
+		if err := auth.SelectProfile(profile); err != nil {
+			ui.Fatal("%s", err)
+		}
+	}
+
+	if args["can"].(bool) {
+		can(args)
+		return
+	}
+
+	if args["discovery"].(bool) {
+		refreshDiscovery(args)
+		return
+	}
+
+	if args["profiles"].(bool) {
+		manageProfiles(args)
+		return
+	}
+
 	if args["login"].(bool) {
 		signIn(args)
 		return
@@ -77,24 +145,69 @@ func main() {
 		tokens(args)
 		return
 	}
+
+	if args["token"].(bool) {
+		exchangeToken(args)
+		return
+	}
 }
 
-// signIn authenticates the user and returns the received identity token.
-func signIn(args map[string]interface{}) {
-	address := args["--provider"].(string)
+// providerAddress returns the identity provider address given with --provider, defaulting to https.
+// It is empty if no address was given.
+func providerAddress(args map[string]interface{}) string {
+	address, _ := args["--provider"].(string)
+	if address == "" {
+		return ""
+	}
 
 	if !strings.HasPrefix(address, "http") {
 		address = fmt.Sprintf("https://%s", address)
 	}
+	return address
+}
+
+// signIn authenticates the user and returns the received identity token.
+func signIn(args map[string]interface{}) {
+	address := providerAddress(args)
+	if address == "" {
+		address = auth.DefaultProvider
+	}
+
+	email, _ := args["--email"].(string)
+	if email != "" {
+		issuer, err := auth.DiscoverIssuer(email)
+		if err != nil {
+			ui.Debug("%+v", err)
+			ui.Fatal("%s", err)
+		}
+		address = issuer
+	}
+
+	if args["--device"].(bool) {
+		signInWithDevice(args, address)
+		return
+	}
+
+	if args["--browser"].(bool) {
+		signInWithBrowser(args, address)
+		return
+	}
+
+	if args["--client-credentials"].(bool) {
+		signInWithClientCredentials(args, address)
+		return
+	}
 
 	ui.Info("Enter credentials for %s\n", address)
 
-	email := ui.Ask("Email: ")
+	if email == "" {
+		email = ui.Ask("Email: ")
+	}
 	password := ui.AskPassword("Password: ")
 
 	s := ui.Spinner()
 	s.Start()
-	if err := auth.SignIn(email, password, address); err != nil {
+	if err := auth.SignIn(email, password, address, listArg(args, "--scope"), listArg(args, "--audience")); err != nil {
 		s.Stop()
 		ui.Info("\r")
 		ui.Debug("%+v", err)
@@ -104,14 +217,157 @@ func signIn(args map[string]interface{}) {
 	ui.Info("\rSigned in successfully.\n")
 }
 
+// signInWithDevice authenticates the user using the device authorization grant.
+func signInWithDevice(args map[string]interface{}, address string) {
+	s := ui.Spinner()
+	err := auth.SignInWithDevice(address, listArg(args, "--scope"), listArg(args, "--audience"), func(userCode, verificationURI string) {
+		ui.Info("To sign in to %s, open %s and enter the code:\n", address, verificationURI)
+		ui.Title(userCode + "\n")
+		s.Start()
+	})
+	s.Stop()
+
+	if err != nil {
+		ui.Info("\r")
+		ui.Debug("%+v", err)
+		ui.Fatal("%s", err)
+	}
+
+	ui.Info("\rSigned in successfully.\n")
+}
+
+// signInWithBrowser authenticates the user through the web browser using the authorization code flow.
+func signInWithBrowser(args map[string]interface{}, address string) {
+	s := ui.Spinner()
+	err := auth.SignInWithBrowser(address, listArg(args, "--scope"), listArg(args, "--audience"), func(authzURL string) error {
+		ui.Info("Opening your browser to sign in to %s. If it does not open, visit:\n%s\n", address, authzURL)
+		if err := openBrowser(authzURL); err != nil {
+			ui.Debug("%+v", err)
+		}
+		s.Start()
+		return nil
+	})
+	s.Stop()
+
+	if err != nil {
+		ui.Info("\r")
+		ui.Debug("%+v", err)
+		ui.Fatal("%s", err)
+	}
+
+	ui.Info("\rSigned in successfully.\n")
+}
+
+// signInWithClientCredentials authenticates a service identity. Credentials are taken from flags first,
+// then from environment variables.
+func signInWithClientCredentials(args map[string]interface{}, address string) {
+	clientID := flagOrEnv(args, "--client-id", "LIFT_CLIENT_ID")
+	clientSecret := flagOrEnv(args, "--client-secret", "LIFT_CLIENT_SECRET")
+
+	if clientSecret == "" {
+		if secretFile := flagOrEnv(args, "--client-secret-file", "LIFT_CLIENT_SECRET_FILE"); secretFile != "" {
+			data, err := ioutil.ReadFile(secretFile)
+			if err != nil {
+				ui.Debug("%+v", err)
+				ui.Fatal("Unable to read client secret file %q", secretFile)
+			}
+			clientSecret = strings.TrimSpace(string(data))
+		}
+	}
+
+	if clientID == "" || clientSecret == "" {
+		ui.Fatal("Client ID and client secret are required for signing in with client credentials.")
+	}
+
+	scope, audience := listArg(args, "--scope"), listArg(args, "--audience")
+	if err := auth.SignInWithClientCredentials(clientID, clientSecret, address, scope, audience); err != nil {
+		ui.Debug("%+v", err)
+		ui.Fatal("%s", err)
+	}
+
+	ui.Info("Signed in successfully as %s.\n", clientID)
+}
+
+// listArg returns the values of a repeatable option. Values holding several space or comma separated
+// items, such as --scope "openid email", are split.
+func listArg(args map[string]interface{}, flag string) []string {
+	var values []string
+	switch v := args[flag].(type) {
+	case string:
+		values = []string{v}
+	case []string:
+		values = v
+	}
+
+	var list []string
+	for _, value := range values {
+		list = append(list, strings.FieldsFunc(value, func(r rune) bool {
+			return r == ' ' || r == ','
+		})...)
+	}
+	return list
+}
+
+// flagOrEnv returns the value of the given flag, if set, or the value of the environment variable.
+func flagOrEnv(args map[string]interface{}, flag, env string) string {
+	if v, ok := args[flag].(string); ok && v != "" {
+		return v
+	}
+	return os.Getenv(env)
+}
+
 // signOut terminates the user session with the OpenID Provider.
 func signOut(args map[string]interface{}) {
-	auth.SignOut()
+	strict := args["--strict"].(bool)
+
+	var results []auth.TokenRevocation
+	var err error
+	if args["--global"].(bool) {
+		s := ui.Spinner()
+		results, err = auth.SignOutGlobally(strict, func(logoutURL string) error {
+			ui.Info("Opening your browser to sign out. If it does not open, visit:\n%s\n", logoutURL)
+			if err := openBrowser(logoutURL); err != nil {
+				ui.Debug("%+v", err)
+			}
+			s.Start()
+			return nil
+		})
+		s.Stop()
+		ui.Info("\r")
+	} else {
+		results, err = auth.SignOutWithResults(strict)
+	}
+
+	if args["--verbose"].(bool) {
+		for _, result := range results {
+			token := result.TokenType
+			if result.Audience != "" {
+				token += " for " + result.Audience
+			}
+
+			if result.Err != nil {
+				ui.Info("%s: not revoked, %s\n", token, result.Err)
+				continue
+			}
+			ui.Info("%s: revoked\n", token)
+		}
+	}
+
+	if err != nil {
+		ui.Debug("%+v", err)
+		ui.Fatal("%s", err)
+	}
+
 	ui.Info("Signed out successfully.\n")
 }
 
 // whoami prints the email of the user currently logged.
 func whoami(args map[string]interface{}) {
+	if args["--full"].(bool) {
+		whoamiFull()
+		return
+	}
+
 	email, err := auth.WhoAmI()
 	if err != nil {
 		ui.Debug("%+v", err)
@@ -121,8 +377,36 @@ func whoami(args map[string]interface{}) {
 	ui.Info("%s\n", email)
 }
 
+// whoamiFull prints details about the current session.
+func whoamiFull() {
+	identity, err := auth.CurrentIdentity()
+	if err != nil {
+		ui.Debug("%+v", err)
+		ui.Fatal("%s", err)
+	}
+
+	if identity.ServiceIdentity {
+		ui.Info("Client ID:       %s\n", identity.Name)
+	} else {
+		ui.Info("Name:            %s\n", identity.Name)
+		ui.Info("Email:           %s\n", identity.Email)
+		ui.Info("Email verified:  %t\n", identity.EmailVerified)
+	}
+	ui.Info("Subject:         %s\n", identity.Subject)
+	ui.Info("Issuer:          %s\n", identity.Issuer)
+	ui.Info("Scopes:          %s\n", strings.Join(identity.Scopes, " "))
+	if !identity.ExpiresAt.IsZero() {
+		ui.Info("Session expires: %s\n", identity.ExpiresAt.Format(time.RFC1123))
+	}
+}
+
 // token prints the ID and Access tokens of the currently logged user.
 func tokens(args map[string]interface{}) {
+	if args["inspect"].(bool) {
+		inspectTokens(args)
+		return
+	}
+
 	idToken, accessToken, err := auth.Tokens()
 	if err != nil {
 		ui.Debug("%+v", err)
@@ -134,3 +418,130 @@ func tokens(args map[string]interface{}) {
 	ui.Title("Access Token\n")
 	ui.Info("%s\n", accessToken)
 }
+
+// exchangeToken prints an access token restricted to the given audience and scopes.
+func exchangeToken(args map[string]interface{}) {
+	audience := listArg(args, "--audience")
+	if len(audience) != 1 {
+		ui.Fatal("A single audience is required.")
+	}
+
+	accessToken, err := auth.ExchangeToken(audience[0], listArg(args, "--scope"))
+	if err != nil {
+		ui.Debug("%+v", err)
+		ui.Fatal("%s", err)
+	}
+
+	ui.Info("%s\n", accessToken)
+}
+
+// inspectTokens prints decoded token claims or, with --remote, the provider's introspection result.
+func inspectTokens(args map[string]interface{}) {
+	if !args["--remote"].(bool) {
+		idToken, accessToken, err := auth.TokenClaims()
+		if err != nil {
+			ui.Debug("%+v", err)
+			ui.Fatal("No tokens found. Please sign in first.")
+		}
+
+		if idToken != nil {
+			ui.Title("ID Token\n")
+			printJSON(idToken)
+		}
+		if accessToken != nil {
+			ui.Title("Access Token\n")
+			printJSON(accessToken)
+		}
+		return
+	}
+
+	results, err := auth.IntrospectTokens()
+	if err != nil {
+		ui.Debug("%+v", err)
+		ui.Fatal("%s", err)
+	}
+
+	for _, result := range results {
+		ui.Title(result.TokenType + "\n")
+		printJSON(result.Introspection)
+	}
+}
+
+// printJSON prints v as indented JSON.
+func printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		ui.Debug("%+v", err)
+		ui.Fatal("Unable to display token claims.")
+	}
+	ui.Info("%s\n", data)
+}
+
+// can exits with a non-zero status if the current session was not granted all the given scopes,
+// so that scripts can gate steps on them. Scripts can tell a denial, exiting with 1, from failing
+// to find out, exiting with 2.
+func can(args map[string]interface{}) {
+	scopes := args["<scope>"].([]string)
+
+	allowed, err := auth.Can(scopes...)
+	if err != nil {
+		ui.Debug("%+v", err)
+		ui.Error("%s\n", err)
+		os.Exit(2)
+	}
+
+	if !allowed {
+		ui.Info("Not allowed: %s\n", strings.Join(scopes, " "))
+		os.Exit(1)
+	}
+	ui.Info("Allowed: %s\n", strings.Join(scopes, " "))
+}
+
+// refreshDiscovery refreshes the cached identity provider configuration and signing keys.
+func refreshDiscovery(args map[string]interface{}) {
+	address, err := auth.RefreshDiscovery(providerAddress(args), args["--force"].(bool))
+	if err != nil {
+		ui.Debug("%+v", err)
+		ui.Fatal("%s", err)
+	}
+
+	ui.Info("Identity provider configuration refreshed from %s.\n", address)
+}
+
+// manageProfiles lists, selects or deletes profiles.
+func manageProfiles(args map[string]interface{}) {
+	if args["list"].(bool) {
+		names, current, err := auth.Profiles()
+		if err != nil {
+			ui.Debug("%+v", err)
+			ui.Fatal("%s", err)
+		}
+
+		for _, name := range names {
+			if name == current {
+				ui.Info("* %s\n", name)
+				continue
+			}
+			ui.Info("  %s\n", name)
+		}
+		return
+	}
+
+	name := args["<name>"].(string)
+	if args["use"].(bool) {
+		if err := auth.UseProfile(name); err != nil {
+			ui.Debug("%+v", err)
+			ui.Fatal("%s", err)
+		}
+		ui.Info("Using profile %q.\n", name)
+		return
+	}
+
+	if args["delete"].(bool) {
+		if err := auth.DeleteProfile(name); err != nil {
+			ui.Debug("%+v", err)
+			ui.Fatal("%s", err)
+		}
+		ui.Info("Profile %q deleted.\n", name)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestListArg(t *testing.T) {
+	tests := []struct {
+		desc     string
+		value    interface{}
+		expected []string
+	}{
+		{"missing", nil, nil},
+		{"single value", "openid", []string{"openid"}},
+		{"repeated option", []string{"openid", "email"}, []string{"openid", "email"}},
+		{"space separated", []string{"openid email"}, []string{"openid", "email"}},
+		{"comma separated", []string{"openid,email, deploy"}, []string{"openid", "email", "deploy"}},
+		{"empty items", []string{",openid,,", ""}, []string{"openid"}},
+	}
+
+	for _, tt := range tests {
+		args := map[string]interface{}{"--scope": tt.value}
+		if list := listArg(args, "--scope"); !reflect.DeepEqual(list, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.desc, tt.expected, list)
+		}
+	}
+}
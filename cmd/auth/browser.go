@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os/exec"
+	"runtime"
+)
+
+// openBrowser opens the given URL using the default browser of the user's platform.
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
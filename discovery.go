@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/lift-plugins/auth/openidc/discovery"
+)
+
+// RefreshDiscovery revalidates the cached OpenID Connect configuration and signing keys of the
+// given provider, even if they are still fresh. With force, cached copies are ignored and
+// everything is downloaded again. An empty address refreshes the provider the cached configuration
+// belongs to, or DefaultProvider if there is none. It returns the address refreshed from.
+func RefreshDiscovery(address string, force bool) (string, error) {
+	return NewClient(WithProvider(address)).RefreshDiscovery(context.Background(), force)
+}
+
+// RefreshDiscovery revalidates the cached configuration and signing keys of the provider set with
+// WithProvider, or else of the cached issuer. See RefreshDiscovery function for details.
+func (c *Client) RefreshDiscovery(ctx context.Context, force bool) (string, error) {
+	ctx, err := c.bind(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	address := c.provider
+	if address == "" {
+		address = DefaultProvider
+		config := new(discovery.ProviderConfig)
+		if err := config.Read(c.store); err == nil && config.Issuer != "" {
+			address = config.Issuer
+		}
+	}
+
+	if err := discovery.Refresh(ctx, c.store, address, force); err != nil {
+		return "", errors.Wrapf(err, "failed refreshing identity config from %q", address)
+	}
+	return address, nil
+}
+
+// DiscoverIssuer resolves the address of the identity provider of the given email address
+// using WebFinger.
+func DiscoverIssuer(email string) (string, error) {
+	return NewClient().DiscoverIssuer(context.Background(), email)
+}
+
+// DiscoverIssuer resolves the identity provider of email. See DiscoverIssuer function for details.
+func (c *Client) DiscoverIssuer(ctx context.Context, email string) (string, error) {
+	ctx, err := c.bind(ctx)
+	if err != nil {
+		return "", err
+	}
+	return discovery.WebFinger(ctx, email)
+}
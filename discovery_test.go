@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lift-plugins/auth/openidc/discovery"
+)
+
+func TestRefreshDiscoveryUsesCachedIssuer(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+
+	config := &discovery.ProviderConfig{Issuer: p.URL}
+	if err := config.Write(p.store); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewClient(WithHTTPClient(p.Client()), WithStore(p.store))
+	address, err := c.RefreshDiscovery(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if address != p.URL {
+		t.Errorf("expected refresh from the cached issuer %q, got %q", p.URL, address)
+	}
+
+	if err := config.Read(p.store); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.JWKSURI != p.URL+"/jwks" {
+		t.Errorf("expected provider configuration to be refreshed, got %+v", config)
+	}
+}
+
+func TestRefreshDiscoveryExplicitProvider(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+
+	config := &discovery.ProviderConfig{Issuer: "https://id.example.com"}
+	if err := config.Write(p.store); err != nil {
+		t.Fatal(err)
+	}
+
+	address, err := p.client().RefreshDiscovery(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if address != p.URL {
+		t.Errorf("expected refresh from the given provider %q, got %q", p.URL, address)
+	}
+}
@@ -1,18 +1,18 @@
 package clients
 
 import (
-	"encoding/json"
-	"io/ioutil"
-	"os"
-	"path/filepath"
-
 	"github.com/golang/protobuf/ptypes"
 	api "github.com/hooklift/apis/go/identity"
-	"github.com/hooklift/lift/config"
+	"github.com/lift-plugins/auth/openidc/store"
 	"github.com/pkg/errors"
 )
 
-var clientPath = filepath.Join(config.WorkDir, "client.json")
+const (
+	// clientFile is the name under which client data is stored.
+	clientFile = "client.json"
+	// serviceClientFile is the name under which service identity credentials are stored.
+	serviceClientFile = "service_client.json"
+)
 
 // Client represents the OpenID Connect application used by Lift.
 type Client struct {
@@ -20,30 +20,61 @@ type Client struct {
 	CreatedAt string `json:"created_at"`
 }
 
-// Write persist client data to disk.
-func (c *Client) Write() error {
+// Write persist client data to s.
+func (c *Client) Write(s store.Store) error {
 	c.CreatedAt = ptypes.TimestampString(c.ClientIdIssuedAt)
 
-	data, err := json.MarshalIndent(c, "", "\t")
-	if err != nil {
-		return errors.Wrap(err, "failed marshaling client data")
+	if err := s.Write(clientFile, c); err != nil {
+		return errors.Wrap(err, "failed writing client data")
 	}
+	return nil
+}
 
-	if err := ioutil.WriteFile(clientPath, data, os.FileMode(0600)); err != nil {
-		return errors.Wrapf(err, "failed writing client data to %q", clientPath)
+// Read loads up client data from s.
+func (c *Client) Read(s store.Store) error {
+	if err := s.Read(clientFile, c); err != nil {
+		return errors.Wrap(err, "failed reading client config")
 	}
 	return nil
 }
 
-// Read loads up client data from disk.
-func (c *Client) Read() error {
-	data, err := ioutil.ReadFile(clientPath)
-	if err != nil {
-		return errors.Wrapf(err, "failed reading client config from %q", clientPath)
+// WriteService persists the credentials of a service identity to s. They are kept apart from the
+// client registered for users, so that signing in as a service does not replace it.
+func (c *Client) WriteService(s store.Store) error {
+	c.CreatedAt = ptypes.TimestampString(c.ClientIdIssuedAt)
+
+	if err := s.Write(serviceClientFile, c); err != nil {
+		return errors.Wrap(err, "failed writing service identity credentials")
 	}
+	return nil
+}
 
-	if err := json.Unmarshal(data, &c); err != nil {
-		return errors.Wrapf(err, "failed unmarshaling client config from %q", clientPath)
+// ReadService loads up the credentials of a service identity from s.
+func (c *Client) ReadService(s store.Store) error {
+	if err := s.Read(serviceClientFile, c); err != nil {
+		return errors.Wrap(err, "failed reading service identity credentials")
 	}
 	return nil
 }
+
+// DeleteService removes the credentials of a service identity from s.
+func DeleteService(s store.Store) error {
+	return s.Delete(serviceClientFile)
+}
+
+// Load reads the client tokens were obtained with from s: the service identity credentials if
+// service is true, or the client registered for users otherwise.
+func Load(s store.Store, service bool) (*Client, error) {
+	c := new(Client)
+	if service {
+		return c, c.ReadService(s)
+	}
+	return c, c.Read(s)
+}
+
+// Secrets returns the client secret, so that stores can protect it.
+func (c *Client) Secrets() map[string]*string {
+	return map[string]*string{
+		"client_secret": &c.ClientSecret,
+	}
+}
@@ -0,0 +1,103 @@
+package discovery
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default bounds applied to the max-age advertised by the provider for its configuration and signing
+// keys. The floor keeps us from hammering the provider when it disables caching, the ceiling from
+// missing configuration changes for too long.
+const (
+	defaultMinCacheTTL = 5 * time.Minute
+	defaultMaxCacheTTL = 24 * time.Hour
+)
+
+// CacheInfo holds HTTP caching metadata of a fetched document.
+type CacheInfo struct {
+	// URL is the address the document was fetched from.
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Expires      time.Time `json:"expires"`
+}
+
+// Fresh returns whether the document fetched from url can be used without revalidating it.
+func (c *CacheInfo) Fresh(url string) bool {
+	return c.URL == url && time.Now().Before(c.Expires)
+}
+
+// newRequest returns a GET request for url, conditional on the cached validators if the cached
+// document was fetched from the same url.
+func (c *CacheInfo) newRequest(url string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.URL != url {
+		return req, nil
+	}
+
+	if c.ETag != "" {
+		req.Header.Set("If-None-Match", c.ETag)
+	}
+
+	if c.LastModified != "" {
+		req.Header.Set("If-Modified-Since", c.LastModified)
+	}
+	return req, nil
+}
+
+// update records the caching metadata of a 200 or 304 response, bounding its max-age as set in opts.
+func (c *CacheInfo) update(url string, resp *http.Response, now time.Time, opts Options) {
+	c.URL = url
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		c.ETag = etag
+	}
+
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		c.LastModified = lastModified
+	}
+
+	ttl := maxAge(resp.Header, now)
+	if ttl < opts.MinCacheTTL {
+		ttl = opts.MinCacheTTL
+	}
+
+	if ttl > opts.MaxCacheTTL {
+		ttl = opts.MaxCacheTTL
+	}
+	c.Expires = now.Add(ttl)
+}
+
+// maxAge returns how long a response can be cached according to its Cache-Control or
+// Expires headers. https://tools.ietf.org/html/rfc7234#section-4.2.1
+func maxAge(header http.Header, now time.Time) time.Duration {
+	if cc := header.Get("Cache-Control"); cc != "" {
+		for _, directive := range strings.Split(cc, ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+			switch {
+			case directive == "no-cache" || directive == "no-store":
+				return 0
+			case strings.HasPrefix(directive, "max-age="):
+				seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+				if err != nil || seconds < 0 {
+					return 0
+				}
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		return expires.Sub(date)
+	}
+	return 0
+}
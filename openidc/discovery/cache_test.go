@@ -0,0 +1,79 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCacheUpdate(t *testing.T) {
+	now := time.Now()
+	opts := optionsFrom(context.Background())
+	tests := []struct {
+		header http.Header
+		ttl    time.Duration
+	}{
+		{http.Header{"Cache-Control": {"public, max-age=3600"}}, time.Hour},
+		{http.Header{"Cache-Control": {"no-cache"}}, opts.MinCacheTTL},
+		{http.Header{"Cache-Control": {"max-age=10"}}, opts.MinCacheTTL},
+		{http.Header{"Cache-Control": {"max-age=31536000"}}, opts.MaxCacheTTL},
+		{http.Header{
+			"Date":    {now.UTC().Format(http.TimeFormat)},
+			"Expires": {now.Add(2 * time.Hour).UTC().Format(http.TimeFormat)},
+		}, 2 * time.Hour},
+		{http.Header{}, opts.MinCacheTTL},
+	}
+
+	for _, tt := range tests {
+		c := new(CacheInfo)
+		c.update("https://id.hooklift.io", &http.Response{Header: tt.header}, now, opts)
+
+		// HTTP dates have a resolution of one second.
+		if diff := c.Expires.Sub(now.Add(tt.ttl)); diff > time.Second || diff < -time.Second {
+			t.Errorf("%v: expected expiration in %s, got %s", tt.header, tt.ttl, c.Expires.Sub(now))
+		}
+	}
+}
+
+func TestCacheConditionalRequest(t *testing.T) {
+	c := &CacheInfo{
+		URL:          "https://id.hooklift.io/jwks",
+		ETag:         `"v1"`,
+		LastModified: "Mon, 02 Jan 2006 15:04:05 GMT",
+	}
+
+	req, err := c.newRequest(c.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if req.Header.Get("If-None-Match") != c.ETag || req.Header.Get("If-Modified-Since") != c.LastModified {
+		t.Errorf("expected conditional request, got headers %v", req.Header)
+	}
+
+	req, err = c.newRequest("https://other.io/jwks")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if len(req.Header) != 0 {
+		t.Errorf("validators of a different URL should not be sent, got headers %v", req.Header)
+	}
+}
+
+func TestCacheUpdateBounds(t *testing.T) {
+	now := time.Now()
+	opts := optionsFrom(NewContext(context.Background(), Options{MinCacheTTL: time.Minute, MaxCacheTTL: time.Hour}))
+
+	c := new(CacheInfo)
+	c.update("https://id.hooklift.io", &http.Response{Header: http.Header{"Cache-Control": {"no-cache"}}}, now, opts)
+	if ttl := c.Expires.Sub(now); ttl != time.Minute {
+		t.Errorf("expected the configured floor, got %s", ttl)
+	}
+
+	c.update("https://id.hooklift.io", &http.Response{Header: http.Header{"Cache-Control": {"max-age=86400"}}}, now, opts)
+	if ttl := c.Expires.Sub(now); ttl != time.Hour {
+		t.Errorf("expected the configured ceiling, got %s", ttl)
+	}
+}
@@ -1,29 +1,37 @@
 package discovery
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
-	"os"
-	"path/filepath"
+	neturl "net/url"
 	"strings"
+	"time"
 
-	"github.com/hooklift/lift/config"
 	"github.com/lift-plugins/auth/openidc/oauth2"
+	"github.com/lift-plugins/auth/openidc/store"
 	"github.com/pkg/errors"
 )
 
-var configPath = filepath.Join(config.WorkDir, "openidc.json")
+const (
+	// configFile is the name under which provider configuration is stored.
+	configFile = "openidc.json"
+	// configCacheFile is the name under which caching metadata of the provider configuration is stored.
+	configCacheFile = "openidc.cache.json"
+)
 
 // ProviderConfig contains the OpenID Connect Provider configuration.
 type ProviderConfig struct {
 	Issuer                   string   `json:"issuer"`
 	AuthzEndpoint            string   `json:"authorization_endpoint"`
 	TokenEndpoint            string   `json:"token_endpoint"`
+	DeviceAuthzEndpoint      string   `json:"device_authorization_endpoint"`
 	UserInfoEndpoint         string   `json:"userinfo_endpoint"`
 	RevocationEndpoint       string   `json:"revocation_endpoint"`
+	EndSessionEndpoint       string   `json:"end_session_endpoint"`
+	IntrospectionEndpoint    string   `json:"introspection_endpoint"`
 	RegistrationEndpoint     string   `json:"registration_endpoint"`
 	JWKSURI                  string   `json:"jwks_uri"`
 	PolicyURI                string   `json:"op_policy_uri"`
@@ -35,59 +43,144 @@ type ProviderConfig struct {
 	Scopes                   []string `json:"scopes_supported"`
 	TokenEndpointAuthMethods []string `json:"token_endpoint_auth_methods_supported"`
 	Claims                   []string `json:"claims_supported"`
+
+	cache CacheInfo
 }
 
-// Fetch downloads OpenID provider configuration and loads it in.
-func (c *ProviderConfig) Fetch(address string) error {
-	if !strings.HasPrefix(address, "http") {
-		address = "https://" + address
+// Fetch downloads OpenID provider configuration, validates it and loads it in. If the provider does not
+// implement OpenID Connect Discovery, OAuth 2.0 Authorization Server Metadata is fetched instead, as
+// specified in https://tools.ietf.org/html/rfc8414. If the configuration was previously read from the
+// store, the request is conditional on its cache validators.
+func (c *ProviderConfig) Fetch(ctx context.Context, address string) error {
+	return c.fetchAll(ctx, address, oauth2.DevMode())
+}
+
+// FetchStrict is like Fetch, but validates the configuration strictly even in dev mode. Servers
+// use it, so that LIFT_AUTH_DEV set in their environment cannot make them trust lax providers.
+func (c *ProviderConfig) FetchStrict(ctx context.Context, address string) error {
+	return c.fetchAll(ctx, address, false)
+}
+
+// fetchAll looks up provider configuration in every metadata URL of address, in order of preference.
+// If relaxed is true, the configuration is validated as in dev mode.
+func (c *ProviderConfig) fetchAll(ctx context.Context, address string, relaxed bool) error {
+	for _, mu := range metadataURLs(address) {
+		found, err := c.fetch(ctx, address, mu, relaxed)
+		if err != nil {
+			return err
+		}
+
+		if found {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q does not seem to implement OpenID Connect", address)
+}
+
+// fetch downloads provider configuration from a metadata URL. It returns false if there is no
+// metadata at that URL.
+func (c *ProviderConfig) fetch(ctx context.Context, address string, mu metadataURL, relaxed bool) (bool, error) {
+	url := mu.url
+	req, err := c.cache.newRequest(url)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed preparing request to %q", url)
 	}
 
-	url := fmt.Sprintf("%s/.well-known/openid-configuration", address)
-	resp, err := oauth2.Client.Get(url)
+	now := time.Now()
+	resp, err := oauth2.Do(ctx, req)
 	if err != nil {
-		return errors.Wrapf(err, "failed retrieving identity server configuration from %q", url)
+		return false, errors.Wrapf(err, "failed retrieving identity server configuration from %q", url)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		c.cache.update(url, resp, now, optionsFrom(ctx))
+		return true, nil
+	}
+
 	if resp.StatusCode == http.StatusNotFound {
-		return fmt.Errorf("%q does not seem to implement OpenID Connect", address)
+		return false, nil
 	}
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("failed discovering provider configuration. HTTP status: %d", resp.StatusCode)
+		return false, fmt.Errorf("failed discovering provider configuration. HTTP status: %d", resp.StatusCode)
 	}
 
+	fetched := new(ProviderConfig)
 	decoder := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)) // limits reader to 1mb
-	if err := decoder.Decode(c); err != nil {
-		return errors.Wrapf(err, "failed decoding OpenID provider config from %q", address)
+	if err := decoder.Decode(fetched); err != nil {
+		return false, errors.Wrapf(err, "failed decoding OpenID provider config from %q", address)
 	}
 
-	return nil
+	if err := fetched.validate(address, mu.openID, relaxed); err != nil {
+		return false, err
+	}
+
+	fetched.cache = c.cache
+	fetched.cache.update(url, resp, now, optionsFrom(ctx))
+	*c = *fetched
+	return true, nil
 }
 
-// Read loads the previously fetched OpenID provider configuration.
-func (c *ProviderConfig) Read() error {
-	data, err := ioutil.ReadFile(configPath)
-	if err != nil {
-		return errors.Wrapf(err, "failed reading OpenID provider config file at %q", configPath)
+// Fresh returns whether the configuration was fetched from address and can be used without
+// revalidating it with the provider.
+func (c *ProviderConfig) Fresh(address string) bool {
+	for _, mu := range metadataURLs(address) {
+		if c.cache.Fresh(mu.url) {
+			return true
+		}
+	}
+	return false
+}
+
+// metadataURL is a location where provider configuration may be published.
+type metadataURL struct {
+	url string
+	// openID tells whether the document is an OpenID Connect Discovery one, as opposed to
+	// OAuth 2.0 Authorization Server Metadata.
+	openID bool
+}
+
+// metadataURLs returns the locations where provider configuration is looked up for the given
+// provider address, in order of preference.
+func metadataURLs(address string) []metadataURL {
+	if !strings.HasPrefix(address, "http") {
+		address = "https://" + address
+	}
+	address = strings.TrimSuffix(address, "/")
+
+	// RFC 8414 inserts the well-known path between the host and the path of the issuer.
+	// https://tools.ietf.org/html/rfc8414#section-3.1
+	oauthURL := address + "/.well-known/oauth-authorization-server"
+	if u, err := neturl.Parse(address); err == nil && u.Path != "" {
+		oauthURL = u.Scheme + "://" + u.Host + "/.well-known/oauth-authorization-server" + u.Path
+	}
+
+	return []metadataURL{
+		{url: address + "/.well-known/openid-configuration", openID: true},
+		{url: oauthURL},
 	}
+}
 
-	if err := json.Unmarshal(data, c); err != nil {
-		return errors.Wrapf(err, "failed unmarshaling OpenID provider config file at %q", configPath)
+// Read loads the previously fetched OpenID provider configuration from s, along with its caching metadata.
+func (c *ProviderConfig) Read(s store.Store) error {
+	if err := s.Read(configFile, c); err != nil {
+		return errors.Wrap(err, "failed reading OpenID provider config")
 	}
+
+	// Missing caching metadata only means the next fetch is not conditional.
+	s.Read(configCacheFile, &c.cache)
 	return nil
 }
 
-// Write stores the current configuration into its disk file.
-func (c *ProviderConfig) Write() error {
-	data, err := json.MarshalIndent(c, "", "\t")
-	if err != nil {
-		return errors.Wrap(err, "failed marshaling OpenID provider config")
+// Write stores the current configuration in s, along with its caching metadata.
+func (c *ProviderConfig) Write(s store.Store) error {
+	if err := s.Write(configFile, c); err != nil {
+		return errors.Wrap(err, "failed writing OpenID provider config")
 	}
 
-	if err := ioutil.WriteFile(configPath, data, os.FileMode(0600)); err != nil {
-		return errors.Wrapf(err, "failed writing OpenID provider config to %q", configPath)
+	if err := s.Write(configCacheFile, &c.cache); err != nil {
+		return errors.Wrap(err, "failed writing OpenID provider config caching metadata")
 	}
 	return nil
 }
@@ -0,0 +1,78 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/lift-plugins/auth/openidc/oauth2"
+)
+
+func TestMetadataURLs(t *testing.T) {
+	tests := []struct {
+		address string
+		urls    []metadataURL
+	}{
+		{"https://id.hooklift.io", []metadataURL{
+			{url: "https://id.hooklift.io/.well-known/openid-configuration", openID: true},
+			{url: "https://id.hooklift.io/.well-known/oauth-authorization-server"},
+		}},
+		{"id.hooklift.io/tenant/", []metadataURL{
+			{url: "https://id.hooklift.io/tenant/.well-known/openid-configuration", openID: true},
+			{url: "https://id.hooklift.io/.well-known/oauth-authorization-server/tenant"},
+		}},
+	}
+
+	for _, tt := range tests {
+		if urls := metadataURLs(tt.address); !reflect.DeepEqual(urls, tt.urls) {
+			t.Errorf("%s: expected %+v, got %+v", tt.address, tt.urls, urls)
+		}
+	}
+}
+
+func TestFetchFallsBackToOAuthMetadata(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/oauth-authorization-server" {
+			http.NotFound(w, r)
+			return
+		}
+
+		// OAuth 2.0 metadata does not require OpenID Connect fields, such as subject types.
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                   srv.URL,
+			"authorization_endpoint":   srv.URL + "/authorize",
+			"token_endpoint":           srv.URL + "/token",
+			"jwks_uri":                 srv.URL + "/jwks",
+			"response_types_supported": []string{"code"},
+		})
+	}))
+	defer srv.Close()
+
+	config := new(ProviderConfig)
+	ctx := oauth2.NewContext(context.Background(), srv.Client())
+	if err := config.Fetch(ctx, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.TokenEndpoint != srv.URL+"/token" {
+		t.Errorf("expected configuration from OAuth 2.0 metadata, got %+v", config)
+	}
+
+	if !config.Fresh(srv.URL) {
+		t.Error("expected configuration to be cached for the metadata URL it was fetched from")
+	}
+}
+
+func TestFetchNotFound(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	ctx := oauth2.NewContext(context.Background(), srv.Client())
+	if err := new(ProviderConfig).Fetch(ctx, srv.URL); err == nil {
+		t.Error("expected an error when no metadata is published")
+	}
+}
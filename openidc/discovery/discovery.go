@@ -1,22 +1,57 @@
 package discovery
 
-// Run downloads provider configuration, signing keys and store those to disk.
-func Run(address string) error {
+import (
+	"context"
+
+	"github.com/lift-plugins/auth/openidc/store"
+)
+
+// Run downloads provider configuration, signing keys and writes those to s. Copies cached
+// from a previous run are used as long as they are fresh, and revalidated with the provider
+// otherwise.
+func Run(ctx context.Context, s store.Store, address string) error {
+	return run(ctx, s, address, false, false)
+}
+
+// Refresh revalidates cached provider configuration and signing keys with the provider, even if
+// they are still fresh. With force, cached copies are ignored and downloaded again.
+func Refresh(ctx context.Context, s store.Store, address string, force bool) error {
+	return run(ctx, s, address, true, force)
+}
+
+func run(ctx context.Context, s store.Store, address string, revalidate, force bool) error {
 	config := new(ProviderConfig)
-	if err := config.Fetch(address); err != nil {
-		return err
+	config.Read(s)
+
+	// Previously stored keys are loaded so that rotated keys get retired instead of dropped.
+	keys := new(SigningKeys)
+	keys.Read(s)
+
+	// Dropping cache validators makes requests unconditional.
+	if force {
+		config.cache = CacheInfo{}
+		keys.cache = CacheInfo{}
 	}
 
-	if err := config.Write(); err != nil {
-		return err
+	if revalidate || !config.Fresh(address) {
+		if err := config.Fetch(ctx, address); err != nil {
+			return err
+		}
+
+		if err := config.Write(s); err != nil {
+			return err
+		}
 	}
 
-	keys := new(SigningKeys)
-	if err := keys.Fetch(config.JWKSURI); err != nil {
+	if !revalidate && keys.Fresh(config.JWKSURI) {
+		return nil
+	}
+
+	if err := keys.Fetch(ctx, config.JWKSURI); err != nil {
 		return err
 	}
 
-	if err := keys.Write(); err != nil {
+	if err := keys.Write(s); err != nil {
 		return err
 	}
 	return nil
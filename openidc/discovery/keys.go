@@ -1,85 +1,240 @@
 package discovery
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"os"
-	"path/filepath"
+	"net/http"
+	"strings"
+	"time"
 
 	jose "gopkg.in/square/go-jose.v2"
 
-	"github.com/hooklift/lift/config"
-	"github.com/hooklift/lift/ui"
 	"github.com/lift-plugins/auth/openidc/oauth2"
+	"github.com/lift-plugins/auth/openidc/store"
 	"github.com/pkg/errors"
 )
 
-var jwksPath = filepath.Join(config.WorkDir, "jwks.json")
+const (
+	// jwksFile is the name under which signing keys are stored.
+	jwksFile = "jwks.json"
+	// jwksCacheFile is the name under which caching metadata of signing keys is stored.
+	jwksCacheFile = "jwks.cache.json"
+
+	// refetchInterval is the minimum time between fetches of signing keys triggered by unknown key IDs.
+	// It keeps tokens with forged key IDs from making us flood the provider with requests.
+	refetchInterval = 5 * time.Minute
+
+	// keyGracePeriod is how long keys are still accepted after the provider stops publishing them,
+	// so that tokens signed right before a key rotation can still be verified.
+	keyGracePeriod = 24 * time.Hour
+)
+
+// InvalidKeysError is returned when none of the keys published by the provider are valid.
+type InvalidKeysError struct {
+	// URI is the address the keys were fetched from.
+	URI string
+	// KeyIDs are the IDs of the invalid keys.
+	KeyIDs []string
+}
+
+func (e *InvalidKeysError) Error() string {
+	return fmt.Sprintf("none of the signing keys received from %q are valid: %s", e.URI, strings.Join(e.KeyIDs, ", "))
+}
 
 // SigningKeys represents the OpenID provider signing keys.
 type SigningKeys struct {
 	Keys map[string]jose.JSONWebKey `json:"keys"`
+	// Retired holds keys no longer published by the provider, during their grace period.
+	Retired map[string]RetiredKey `json:"retired,omitempty"`
+	// FetchedAt is the last time keys were fetched, or attempted to.
+	FetchedAt time.Time `json:"fetched_at"`
+
+	cache CacheInfo
 }
 
-// Fetch downloads OpenID provider signing keys.
-func (k *SigningKeys) Fetch(jwkURI string) error {
-	resp, err := oauth2.Client.Get(jwkURI)
+// RetiredKey is a signing key no longer published by the provider.
+type RetiredKey struct {
+	Key       jose.JSONWebKey `json:"key"`
+	RetiredAt time.Time       `json:"retired_at"`
+}
+
+// Fetch downloads OpenID provider signing keys. Previously loaded keys that are no longer
+// published are retired. Invalid keys are reported through the InvalidKeyHook carried by ctx, see
+// NewContext, and skipped. An *InvalidKeysError is returned only if there are no valid keys left.
+func (k *SigningKeys) Fetch(ctx context.Context, jwkURI string) error {
+	opts := optionsFrom(ctx)
+	now := time.Now()
+	k.FetchedAt = now
+
+	req, err := k.cache.newRequest(jwkURI)
+	if err != nil {
+		return errors.Wrapf(err, "failed preparing request to %q", jwkURI)
+	}
+
+	resp, err := oauth2.Do(ctx, req)
 	if err != nil {
 		return errors.Wrap(err, "failed to get OpenID provider signing keys.")
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		k.cache.update(jwkURI, resp, now, opts)
+		return nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed retrieving signing keys from %q. HTTP status: %d", jwkURI, resp.StatusCode)
+	}
+
 	decoder := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)) // limits reading to 1mb only.
 
-	var keySet jose.JSONWebKeySet
+	// Keys are decoded one by one, so that a single invalid key does not prevent using the rest.
+	var keySet struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
 	if err := decoder.Decode(&keySet); err != nil {
 		return errors.Wrapf(err, "failed decoding signing keys received from %q", jwkURI)
 	}
 
-	k.Keys = make(map[string]jose.JSONWebKey, len(keySet.Keys))
-	for _, key := range keySet.Keys {
-		if !key.Valid() {
-			// TODO(c4milo): send metric to alert Hooklift security team about this.
-			ui.Fatal("JSON Web Key %q is not a valid crypto key \n", key.KeyID)
+	published := make(map[string]jose.JSONWebKey, len(keySet.Keys))
+	invalid := &InvalidKeysError{URI: jwkURI}
+	for _, raw := range keySet.Keys {
+		var key jose.JSONWebKey
+		err := key.UnmarshalJSON(raw)
+		if err == nil && !key.Valid() {
+			err = errors.New("not a valid crypto key")
+		}
+
+		if err != nil {
+			var id struct {
+				KeyID string `json:"kid"`
+			}
+			json.Unmarshal(raw, &id)
+
+			opts.InvalidKeyHook(jwkURI, id.KeyID, err)
+			invalid.KeyIDs = append(invalid.KeyIDs, id.KeyID)
+			continue
 		}
-		k.Keys[key.KeyID] = key
+		published[key.KeyID] = key
 	}
+
+	if len(published) == 0 && len(invalid.KeyIDs) > 0 {
+		return invalid
+	}
+
+	k.retire(published, now)
+	k.Keys = published
+	k.cache.update(jwkURI, resp, now, opts)
 	return nil
 }
 
-// Read loads cached OpenID provider signing keys.
-func (k *SigningKeys) Read() error {
-	data, err := ioutil.ReadFile(jwksPath)
-	if err != nil {
-		return errors.Wrapf(err, "failed reading OpenID provider config file at %q", jwksPath)
+// Copy returns a copy of k, that can be fetched into while k is still in use.
+func (k *SigningKeys) Copy() *SigningKeys {
+	c := *k
+	c.Keys = make(map[string]jose.JSONWebKey, len(k.Keys))
+	for kid, key := range k.Keys {
+		c.Keys[kid] = key
 	}
 
-	if err := json.Unmarshal(data, k); err != nil {
-		return errors.Wrapf(err, "failed unmarshaling OpenID provider config file at %q", jwksPath)
+	if k.Retired != nil {
+		c.Retired = make(map[string]RetiredKey, len(k.Retired))
+		for kid, retired := range k.Retired {
+			c.Retired[kid] = retired
+		}
 	}
+	return &c
+}
+
+// Fresh returns whether the keys were fetched from jwkURI and can be used without revalidating
+// them with the provider.
+func (k *SigningKeys) Fresh(jwkURI string) bool {
+	return k.cache.Fresh(jwkURI)
+}
+
+// retire moves current keys missing from the published ones to the retired keys, and drops
+// retired keys past their grace period or published again.
+func (k *SigningKeys) retire(published map[string]jose.JSONWebKey, now time.Time) {
+	if k.Retired == nil {
+		k.Retired = make(map[string]RetiredKey)
+	}
+
+	for kid, key := range k.Keys {
+		if _, ok := published[kid]; ok {
+			continue
+		}
+
+		if _, ok := k.Retired[kid]; !ok {
+			k.Retired[kid] = RetiredKey{Key: key, RetiredAt: now}
+		}
+	}
+
+	for kid, retired := range k.Retired {
+		_, ok := published[kid]
+		if ok || now.Sub(retired.RetiredAt) > keyGracePeriod {
+			delete(k.Retired, kid)
+		}
+	}
+}
+
+// Read loads cached OpenID provider signing keys from s, along with their caching metadata.
+func (k *SigningKeys) Read(s store.Store) error {
+	if err := s.Read(jwksFile, k); err != nil {
+		return errors.Wrap(err, "failed reading OpenID provider signing keys")
+	}
+
+	// Missing caching metadata only means the next fetch is not conditional.
+	s.Read(jwksCacheFile, &k.cache)
 	return nil
 }
 
-// Key returns a cached key by its ID.
+// Key returns a cached key by its ID. Retired keys are returned during their grace period.
 func (k *SigningKeys) Key(kid string) (jose.JSONWebKey, error) {
-	v, ok := k.Keys[kid]
-	if !ok {
-		return jose.JSONWebKey{}, fmt.Errorf("signing key %q not found", kid)
+	if v, ok := k.Keys[kid]; ok {
+		return v, nil
+	}
+
+	if retired, ok := k.Retired[kid]; ok && time.Since(retired.RetiredAt) <= keyGracePeriod {
+		return retired.Key, nil
 	}
-	return v, nil
+	return jose.JSONWebKey{}, fmt.Errorf("signing key %q not found", kid)
 }
 
-// Write writes current keys to disk file.
-func (k *SigningKeys) Write() error {
-	data, err := json.MarshalIndent(k, "", "\t")
-	if err != nil {
-		return errors.Wrap(err, "failed marshaling OpenID provider signing keys")
+// Lookup returns a cached key by its ID. If the key is unknown, such as after the provider
+// rotated its keys, keys are fetched again from jwkURI and written to s. Fetches are rate limited,
+// sharing the limit with other processes through the stored keys.
+func (k *SigningKeys) Lookup(ctx context.Context, s store.Store, kid, jwkURI string) (jose.JSONWebKey, error) {
+	key, err := k.Key(kid)
+	if err == nil {
+		return key, nil
+	}
+
+	if time.Since(k.FetchedAt) < refetchInterval {
+		return key, errors.Wrap(err, "signing keys were fetched recently")
+	}
+
+	fetchErr := k.Fetch(ctx, jwkURI)
+
+	// Keys are stored even if fetching failed, to record the attempt.
+	if err := k.Write(s); err != nil {
+		return key, err
+	}
+
+	if fetchErr != nil {
+		return key, fetchErr
+	}
+	return k.Key(kid)
+}
+
+// Write writes current keys to s, along with their caching metadata.
+func (k *SigningKeys) Write(s store.Store) error {
+	if err := s.Write(jwksFile, k); err != nil {
+		return errors.Wrap(err, "failed writing OpenID provider signing keys")
 	}
 
-	if err := ioutil.WriteFile(jwksPath, data, os.FileMode(0600)); err != nil {
-		return errors.Wrapf(err, "failed writing OpenID provider signing keys %q", jwksPath)
+	if err := s.Write(jwksCacheFile, &k.cache); err != nil {
+		return errors.Wrap(err, "failed writing OpenID provider signing keys caching metadata")
 	}
 	return nil
 }
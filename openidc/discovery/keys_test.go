@@ -0,0 +1,99 @@
+package discovery
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func TestRetire(t *testing.T) {
+	now := time.Now()
+	k := &SigningKeys{
+		Keys: map[string]jose.JSONWebKey{
+			"old":     {KeyID: "old"},
+			"current": {KeyID: "current"},
+		},
+		Retired: map[string]RetiredKey{
+			"expired": {Key: jose.JSONWebKey{KeyID: "expired"}, RetiredAt: now.Add(-2 * keyGracePeriod)},
+		},
+	}
+
+	published := map[string]jose.JSONWebKey{
+		"current": {KeyID: "current"},
+		"new":     {KeyID: "new"},
+	}
+	k.retire(published, now)
+	k.Keys = published
+
+	for _, kid := range []string{"old", "current", "new"} {
+		if _, err := k.Key(kid); err != nil {
+			t.Errorf("expected key %q to be found: %v", kid, err)
+		}
+	}
+
+	if _, err := k.Key("expired"); err == nil {
+		t.Error("expected key past its grace period to be dropped")
+	}
+
+	if _, ok := k.Retired["current"]; ok {
+		t.Error("published key should not be retired")
+	}
+}
+
+func TestLookupRateLimit(t *testing.T) {
+	fetchedAt := time.Now().Add(-time.Minute)
+	k := &SigningKeys{FetchedAt: fetchedAt}
+
+	if _, err := k.Lookup(context.Background(), nil, "forged", "http://invalid.invalid/jwks"); err == nil {
+		t.Fatal("expected error for unknown key")
+	}
+
+	if !k.FetchedAt.Equal(fetchedAt) {
+		t.Error("keys should not be fetched again within the rate limit interval")
+	}
+}
+
+func TestFetchSkipsInvalidKeys(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	valid, err := json.Marshal(jose.JSONWebKey{Key: &ecKey.PublicKey, KeyID: "valid", Algorithm: "ES256", Use: "sig"})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"keys": [` + string(valid) + `, {"kty": "EC", "kid": "broken", "crv": "P-256", "x": "AA", "y": "AA"}]}`))
+	}))
+	defer server.Close()
+
+	var reported []string
+	ctx := NewContext(context.Background(), Options{
+		InvalidKeyHook: func(jwkURI, kid string, err error) {
+			reported = append(reported, kid)
+		},
+	})
+
+	k := new(SigningKeys)
+	if err := k.Fetch(ctx, server.URL); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if _, err := k.Key("valid"); err != nil {
+		t.Errorf("expected valid key to be kept: %v", err)
+	}
+
+	if len(reported) != 1 || reported[0] != "broken" {
+		t.Errorf("expected broken key to be reported, got %v", reported)
+	}
+}
@@ -0,0 +1,52 @@
+package discovery
+
+import (
+	"context"
+	"time"
+
+	"github.com/hooklift/lift/ui"
+)
+
+// Options customizes how provider configuration and signing keys are fetched. Zero values are
+// replaced by defaults.
+type Options struct {
+	// InvalidKeyHook is called for every invalid key found in the provider's key set, before skipping
+	// it. Programs can set it to send security telemetry. By default, invalid keys are only reported
+	// when debugging.
+	InvalidKeyHook func(jwkURI, kid string, err error)
+
+	// MinCacheTTL and MaxCacheTTL bound the max-age advertised by the provider for its configuration
+	// and signing keys. They default to 5 minutes and 24 hours.
+	MinCacheTTL time.Duration
+	MaxCacheTTL time.Duration
+}
+
+type optionsKey struct{}
+
+// NewContext returns a copy of ctx carrying opts, which are used by fetches made with the returned
+// context. Like oauth2.NewContext, it lets options reach fetches made on behalf of other packages.
+func NewContext(ctx context.Context, opts Options) context.Context {
+	return context.WithValue(ctx, optionsKey{}, opts)
+}
+
+// optionsFrom returns the options carried by ctx, with defaults for the ones not set.
+func optionsFrom(ctx context.Context) Options {
+	opts, _ := ctx.Value(optionsKey{}).(Options)
+	if opts.InvalidKeyHook == nil {
+		opts.InvalidKeyHook = debugInvalidKey
+	}
+
+	if opts.MinCacheTTL == 0 {
+		opts.MinCacheTTL = defaultMinCacheTTL
+	}
+
+	if opts.MaxCacheTTL == 0 {
+		opts.MaxCacheTTL = defaultMaxCacheTTL
+	}
+	return opts
+}
+
+// debugInvalidKey is the default InvalidKeyHook.
+func debugInvalidKey(jwkURI, kid string, err error) {
+	ui.Debug("JSON Web Key %q received from %q is invalid: %+v", kid, jwkURI, err)
+}
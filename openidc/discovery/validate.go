@@ -0,0 +1,137 @@
+package discovery
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/hooklift/lift/ui"
+	"github.com/lift-plugins/auth/openidc/oauth2"
+)
+
+// ConfigError is returned when the provider configuration fails validation.
+type ConfigError struct {
+	// Field is the name of the offending configuration field, such as "issuer" or "jwks_uri".
+	Field string
+	// Reason describes why the field is not valid.
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid %q in OpenID provider configuration: %s", e.Field, e.Reason)
+}
+
+// Validate checks the provider configuration fetched from address, according to
+// http://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata and
+// http://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfigurationValidation
+//
+// In dev mode, issuer mismatches are tolerated and endpoints are not required to use https,
+// since local development providers rarely have those right.
+func (c *ProviderConfig) Validate(address string) error {
+	return c.validate(address, true, oauth2.DevMode())
+}
+
+// validate checks the provider configuration. Fields only required by OpenID Connect Discovery
+// are not required in OAuth 2.0 Authorization Server Metadata.
+// https://tools.ietf.org/html/rfc8414#section-2
+//
+// If relaxed is true, the configuration is validated as in dev mode.
+func (c *ProviderConfig) validate(address string, openID, relaxed bool) error {
+
+	required := map[string]string{
+		"issuer":         c.Issuer,
+		"token_endpoint": c.TokenEndpoint,
+		"jwks_uri":       c.JWKSURI,
+	}
+
+	if !relaxed {
+		required["authorization_endpoint"] = c.AuthzEndpoint
+	}
+
+	for field, value := range required {
+		if value == "" {
+			return &ConfigError{Field: field, Reason: "it is required"}
+		}
+	}
+
+	if !relaxed {
+		requiredLists := map[string][]string{
+			"response_types_supported": c.ResponseTypes,
+		}
+
+		if openID {
+			requiredLists["subject_types_supported"] = c.SubjectTypes
+			requiredLists["id_token_signing_alg_values_supported"] = c.IDTokenSigAlgs
+		}
+
+		for field, values := range requiredLists {
+			if len(values) == 0 {
+				return &ConfigError{Field: field, Reason: "it is required"}
+			}
+		}
+	}
+
+	if normalizeURL(c.Issuer) != normalizeURL(address) {
+		err := &ConfigError{Field: "issuer", Reason: fmt.Sprintf("%q does not match the provider address %q", c.Issuer, address)}
+		if !relaxed {
+			return err
+		}
+		ui.Debug("%s", err)
+	}
+
+	endpoints := map[string]string{
+		"issuer":                        c.Issuer,
+		"authorization_endpoint":        c.AuthzEndpoint,
+		"token_endpoint":                c.TokenEndpoint,
+		"device_authorization_endpoint": c.DeviceAuthzEndpoint,
+		"userinfo_endpoint":             c.UserInfoEndpoint,
+		"revocation_endpoint":           c.RevocationEndpoint,
+		"end_session_endpoint":          c.EndSessionEndpoint,
+		"introspection_endpoint":        c.IntrospectionEndpoint,
+		"registration_endpoint":         c.RegistrationEndpoint,
+		"jwks_uri":                      c.JWKSURI,
+	}
+
+	for field, endpoint := range endpoints {
+		if endpoint == "" {
+			continue
+		}
+
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			return &ConfigError{Field: field, Reason: fmt.Sprintf("%q is not a valid URL", endpoint)}
+		}
+
+		if u.Scheme != "https" && !relaxed {
+			return &ConfigError{Field: field, Reason: fmt.Sprintf("%q does not use https", endpoint)}
+		}
+
+		if field == "issuer" && (u.RawQuery != "" || u.Fragment != "") {
+			return &ConfigError{Field: field, Reason: "it must not have query or fragment components"}
+		}
+	}
+
+	return nil
+}
+
+// normalizeURL lowercases scheme and host, and removes default ports and trailing slashes, so that
+// equivalent provider addresses, such as https://id.hooklift.io:443 and https://id.hooklift.io/,
+// compare equal.
+func normalizeURL(address string) string {
+	if !strings.HasPrefix(address, "http") {
+		address = "https://" + address
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return address
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Host)
+	if (scheme == "https" && strings.HasSuffix(host, ":443")) || (scheme == "http" && strings.HasSuffix(host, ":80")) {
+		host = host[:strings.LastIndex(host, ":")]
+	}
+
+	return scheme + "://" + host + strings.TrimSuffix(u.Path, "/")
+}
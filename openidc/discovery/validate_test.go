@@ -0,0 +1,74 @@
+package discovery
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	valid := func() *ProviderConfig {
+		return &ProviderConfig{
+			Issuer:         "https://id.hooklift.io",
+			AuthzEndpoint:  "https://id.hooklift.io/authorize",
+			TokenEndpoint:  "https://id.hooklift.io/token",
+			JWKSURI:        "https://id.hooklift.io/jwks",
+			ResponseTypes:  []string{"code"},
+			SubjectTypes:   []string{"public"},
+			IDTokenSigAlgs: []string{"ES256"},
+		}
+	}
+
+	tests := []struct {
+		desc    string
+		address string
+		modify  func(*ProviderConfig)
+		field   string
+	}{
+		{"valid", "https://id.hooklift.io", func(c *ProviderConfig) {}, ""},
+		{"equivalent address", "https://id.hooklift.io:443/", func(c *ProviderConfig) {}, ""},
+		{"issuer mismatch", "https://id.hooklift.io", func(c *ProviderConfig) { c.Issuer = "https://evil.io" }, "issuer"},
+		{"missing jwks_uri", "https://id.hooklift.io", func(c *ProviderConfig) { c.JWKSURI = "" }, "jwks_uri"},
+		{"missing signing algorithms", "https://id.hooklift.io", func(c *ProviderConfig) { c.IDTokenSigAlgs = nil }, "id_token_signing_alg_values_supported"},
+		{"plain http endpoint", "https://id.hooklift.io", func(c *ProviderConfig) { c.TokenEndpoint = "http://id.hooklift.io/token" }, "token_endpoint"},
+	}
+
+	for _, tt := range tests {
+		config := valid()
+		tt.modify(config)
+
+		err := config.Validate(tt.address)
+		if tt.field == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			}
+			continue
+		}
+
+		cerr, ok := err.(*ConfigError)
+		if !ok {
+			t.Errorf("%s: expected *ConfigError, got %#v", tt.desc, err)
+			continue
+		}
+
+		if cerr.Field != tt.field {
+			t.Errorf("%s: expected failure on %q, got %q", tt.desc, tt.field, cerr.Field)
+		}
+	}
+}
+
+func TestValidateRelaxed(t *testing.T) {
+	config := &ProviderConfig{
+		Issuer:         "http://localhost:8080",
+		AuthzEndpoint:  "http://localhost:8080/authorize",
+		TokenEndpoint:  "http://localhost:8080/token",
+		JWKSURI:        "http://localhost:8080/jwks",
+		ResponseTypes:  []string{"code"},
+		SubjectTypes:   []string{"public"},
+		IDTokenSigAlgs: []string{"ES256"},
+	}
+
+	if err := config.validate("http://localhost:8080", true, true); err != nil {
+		t.Errorf("expected local providers to be accepted in dev mode: %v", err)
+	}
+
+	if err := config.validate("http://localhost:8080", true, false); err == nil {
+		t.Error("expected strict validation to reject providers not using https")
+	}
+}
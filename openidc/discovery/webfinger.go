@@ -0,0 +1,124 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lift-plugins/auth/openidc/oauth2"
+	"github.com/pkg/errors"
+)
+
+// issuerRel is the WebFinger link relation of OpenID Connect issuers.
+const issuerRel = "http://openid.net/specs/connect/1.0/issuer"
+
+// webFingerResponse is a JSON Resource Descriptor, as specified in https://tools.ietf.org/html/rfc7033#section-4.4
+type webFingerResponse struct {
+	Subject string `json:"subject"`
+	Links   []struct {
+		Rel  string `json:"rel"`
+		Href string `json:"href"`
+	} `json:"links"`
+}
+
+// WebFinger resolves the OpenID Connect issuer of the given email address, querying the WebFinger
+// service of the email domain. http://openid.net/specs/openid-connect-discovery-1_0.html#IssuerDiscovery
+func WebFinger(ctx context.Context, email string) (string, error) {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return "", fmt.Errorf("%q is not a valid email address", email)
+	}
+
+	host := email[at+1:]
+	if !validHost(host) {
+		return "", fmt.Errorf("%q is not a valid email address", email)
+	}
+
+	query := url.Values{
+		"resource": {"acct:" + email},
+		"rel":      {issuerRel},
+	}
+
+	scheme := "https"
+	if oauth2.DevMode() && strings.HasPrefix(host, "localhost") {
+		scheme = "http"
+	}
+
+	endpoint := fmt.Sprintf("%s://%s/.well-known/webfinger?%s", scheme, host, query.Encode())
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed preparing request to %q", endpoint)
+	}
+
+	resp, err := oauth2.Do(ctx, req)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed querying WebFinger at %q", host)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("%q does not publish an OpenID Connect issuer for %q", host, email)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed querying WebFinger at %q. HTTP status: %d", host, resp.StatusCode)
+	}
+
+	jrd := new(webFingerResponse)
+	decoder := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)) // limits reader to 1mb
+	if err := decoder.Decode(jrd); err != nil {
+		return "", errors.Wrapf(err, "failed decoding WebFinger response from %q", host)
+	}
+
+	for _, link := range jrd.Links {
+		if link.Rel != issuerRel {
+			continue
+		}
+
+		u, err := url.Parse(link.Href)
+		if err != nil || u.Host == "" {
+			return "", fmt.Errorf("WebFinger returned an invalid issuer: %q", link.Href)
+		}
+
+		if u.Scheme != "https" && !oauth2.DevMode() {
+			return "", fmt.Errorf("WebFinger returned an issuer not using https: %q", link.Href)
+		}
+		return link.Href, nil
+	}
+
+	return "", fmt.Errorf("%q does not publish an OpenID Connect issuer for %q", host, email)
+}
+
+// validHost returns whether host is a valid DNS hostname, so that email domains cannot change the
+// WebFinger URL with userinfo, ports, paths or queries. In dev mode, ports are allowed for
+// local providers. https://tools.ietf.org/html/rfc1123#section-2.1
+func validHost(host string) bool {
+	if oauth2.DevMode() {
+		if h, port, err := net.SplitHostPort(host); err == nil && port != "" {
+			host = h
+		}
+	}
+
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/lift-plugins/auth/openidc/oauth2"
+)
+
+func TestWebFingerInvalidEmail(t *testing.T) {
+	emails := []string{
+		"", "me", "@corp.com", "me@",
+		"me@corp.com/path",
+		"me@corp.com?q=1",
+		"me@user@corp.com:8080",
+		"me@corp.com:8443",
+		"me@corp.com#fragment",
+		"me@-corp.com",
+		"me@corp..com",
+	}
+
+	for _, email := range emails {
+		if _, err := WebFinger(context.Background(), email); err == nil {
+			t.Errorf("expected error for %q", email)
+		}
+	}
+}
+
+func TestWebFinger(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/webfinger" {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+
+		if resource := r.URL.Query().Get("resource"); resource != "acct:me@corp.example" {
+			t.Errorf("unexpected resource %q", resource)
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"subject": "acct:me@corp.example",
+			"links": []map[string]string{
+				{"rel": "http://webfinger.net/rel/profile-page", "href": "https://corp.example/me"},
+				{"rel": issuerRel, "href": "https://id.corp.example"},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	// Sends requests for the email domain to the test server.
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := srv.Client().Transport
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host != "corp.example" {
+			t.Errorf("expected request to the email domain, got %q", req.URL.Host)
+		}
+		req.URL.Host = target.Host
+		return transport.RoundTrip(req)
+	})}
+
+	issuer, err := WebFinger(oauth2.NewContext(context.Background(), client), "me@corp.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if issuer != "https://id.corp.example" {
+		t.Errorf("unexpected issuer %q", issuer)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
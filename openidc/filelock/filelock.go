@@ -0,0 +1,58 @@
+package filelock
+
+import (
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// locks serializes goroutines of this process, since advisory locks on some platforms
+// are held per process rather than per file descriptor.
+var locks = struct {
+	sync.Mutex
+	m map[string]*sync.Mutex
+}{m: make(map[string]*sync.Mutex)}
+
+// Lock is an advisory, exclusive lock held on a file, shared across processes.
+type Lock struct {
+	f  *os.File
+	mu *sync.Mutex
+}
+
+// Acquire blocks until an exclusive lock is taken on the file at path, creating it if needed.
+func Acquire(path string) (*Lock, error) {
+	locks.Lock()
+	mu, ok := locks.m[path]
+	if !ok {
+		mu = new(sync.Mutex)
+		locks.m[path] = mu
+	}
+	locks.Unlock()
+
+	mu.Lock()
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, os.FileMode(0600))
+	if err != nil {
+		mu.Unlock()
+		return nil, errors.Wrapf(err, "failed opening lock file %q", path)
+	}
+
+	if err := lock(f); err != nil {
+		f.Close()
+		mu.Unlock()
+		return nil, errors.Wrapf(err, "failed locking %q", path)
+	}
+
+	return &Lock{f: f, mu: mu}, nil
+}
+
+// Release releases the lock.
+func (l *Lock) Release() error {
+	defer l.mu.Unlock()
+
+	err := unlock(l.f)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,25 @@
+// +build !windows
+
+package filelock
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lock takes a POSIX record lock over the whole file, waiting for it if needed. fcntl(2) is
+// used instead of flock(2) since the latter is not available on Solaris.
+func lock(f *os.File) error {
+	return unix.FcntlFlock(f.Fd(), unix.F_SETLKW, &unix.Flock_t{
+		Type:   unix.F_WRLCK,
+		Whence: 0,
+	})
+}
+
+func unlock(f *os.File) error {
+	return unix.FcntlFlock(f.Fd(), unix.F_SETLK, &unix.Flock_t{
+		Type:   unix.F_UNLCK,
+		Whence: 0,
+	})
+}
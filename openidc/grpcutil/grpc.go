@@ -1,12 +1,13 @@
 package grpcutil
 
 import (
+	"context"
 	"crypto/x509"
 	"fmt"
 	"net/url"
 	"strings"
 
-	"github.com/hooklift/lift/ui"
+	"github.com/lift-plugins/auth/openidc/store"
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
@@ -16,9 +17,71 @@ import (
 // compilation. See grpc_dev.go
 var tlsCert = ""
 
+// Option configures connections returned by Dial.
+type Option func(*dialOptions)
+
+// dialOptions holds the settings of a connection.
+type dialOptions struct {
+	basicCreds     credentials.PerRPCCredentials
+	isIdempotent   func(method string) bool
+	signInRequired func(err error) error
+}
+
+// newDialOptions returns the settings configured by options, with defaults for the rest.
+func newDialOptions(options []Option) *dialOptions {
+	o := &dialOptions{
+		isIdempotent:   neverIdempotent,
+		signInRequired: signInAgain,
+	}
+	for _, option := range options {
+		option(o)
+	}
+	return o
+}
+
+// WithBasicAuth sends a Basic Authorization header with the given credentials along with every call,
+// instead of the session tokens.
+func WithBasicAuth(username, password string) Option {
+	return func(o *dialOptions) {
+		o.basicCreds = BasicCreds(username, password)
+	}
+}
+
+// WithIdempotent sets the function reporting whether a gRPC method, in "/package.Service/Method" form,
+// can be safely retried after the server rejected its credentials. Method names do not tell whether
+// calls have side effects, so no method is retried by default. Tokens are refreshed after a rejection
+// regardless, so that following calls succeed.
+func WithIdempotent(isIdempotent func(method string) bool) Option {
+	return func(o *dialOptions) {
+		o.isIdempotent = isIdempotent
+	}
+}
+
+// WithSignInRequired sets the function called when the server keeps rejecting credentials after
+// refreshing tokens, or when tokens can no longer be refreshed. The error it returns is returned to
+// the caller in place of the original one. By default, a message asking the user to sign in again
+// is added.
+func WithSignInRequired(hook func(err error) error) Option {
+	return func(o *dialOptions) {
+		o.signInRequired = hook
+	}
+}
+
 // Connection returns a server connection to gRPC service on the provided address, handling token authentication and refreshing.
-// If credentials are provided a Basic Authorization header is sent along.
+// If credentials are provided a Basic Authorization header is sent instead of tokens. Tokens are taken from the default store.
 func Connection(address, userAgent string, creds ...string) (*grpc.ClientConn, error) {
+	var options []Option
+	if len(creds) >= 2 {
+		options = append(options, WithBasicAuth(creds[0], creds[1]))
+	}
+	return Dial(context.Background(), store.Default, address, userAgent, options...)
+}
+
+// Dial is like Connection, but takes tokens from s and is configured through options. Tokens are
+// refreshed with the HTTP client carried by ctx, see oauth2.NewContext.
+func Dial(ctx context.Context, s store.Store, address, userAgent string, options ...Option) (*grpc.ClientConn, error) {
+	o := newDialOptions(options)
+
 	// go-grpc fails if address has a scheme
 	if !strings.HasPrefix(address, "http") {
 		address = fmt.Sprintf("https://%s", address)
@@ -39,26 +102,32 @@ func Connection(address, userAgent string, creds ...string) (*grpc.ClientConn, e
 		certPool := x509.NewCertPool()
 		ok := certPool.AppendCertsFromPEM([]byte(tlsCert))
 		if !ok {
-			ui.Fatal("Unable to append server TLS cert to cert pool")
+			return nil, errors.New("unable to append server TLS cert to cert pool")
 		}
 
 		clientTLS := credentials.NewClientTLSFromCert(certPool, "")
 		clientOpts = append(clientOpts, grpc.WithTransportCredentials(clientTLS))
 	}
 
+	// Basic credentials are sent in place of tokens, so that connections authenticating with them
+	// do not depend on the session, which may have been revoked already.
+	if o.basicCreds != nil {
+		clientOpts = append(clientOpts, grpc.WithPerRPCCredentials(o.basicCreds))
+		return grpc.DialContext(ctx, address, clientOpts...)
+	}
+
 	// We do not fail if there is any problem getting locally stored access token.
 	// Since we want to let RPC calls to public endpoints go through just fine. Instead,
 	// we allow the server to complain back if an endpoint requiring authentication is
 	// attempting to be accessed without an access token or openidc client credentials.
-	tokenCreds, err := accessTokenCreds()
-	if err == nil {
-		clientOpts = append(clientOpts, grpc.WithPerRPCCredentials(tokenCreds))
-	}
-
-	if len(creds) >= 2 {
-		basicCreds := BasicCreds(creds[0], creds[1])
-		clientOpts = append(clientOpts, grpc.WithPerRPCCredentials(basicCreds))
+	if tokenCreds, err := accessTokenCreds(ctx, s); err == nil {
+		// Calls authenticated with access tokens refresh them and retry, if the server rejects them.
+		clientOpts = append(clientOpts,
+			grpc.WithPerRPCCredentials(tokenCreds),
+			grpc.WithUnaryInterceptor(unaryRetryInterceptor(tokenCreds, o)),
+			grpc.WithStreamInterceptor(streamRetryInterceptor(tokenCreds, o)),
+		)
 	}
 
-	return grpc.Dial(address, clientOpts...)
+	return grpc.DialContext(ctx, address, clientOpts...)
 }
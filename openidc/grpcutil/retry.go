@@ -0,0 +1,147 @@
+package grpcutil
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// neverIdempotent is the default for WithIdempotent. Method names do not tell whether calls have
+// side effects, so no method is retried.
+func neverIdempotent(method string) bool {
+	return false
+}
+
+// signInAgain is the default for WithSignInRequired, adding a message asking the user to sign in again.
+func signInAgain(err error) error {
+	return grpc.Errorf(codes.Unauthenticated, "%s. Please sign in again", grpc.ErrorDesc(err))
+}
+
+// unaryRetryInterceptor refreshes tokens when a call fails with codes.Unauthenticated, retrying
+// it once if it is idempotent.
+func unaryRetryInterceptor(creds *tokenCreds, o *dialOptions) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if grpc.Code(err) != codes.Unauthenticated {
+			return err
+		}
+
+		if err := creds.forceRefresh(ctx); err != nil {
+			return o.signInRequired(err)
+		}
+
+		// Tokens are refreshed anyway, so that following calls succeed.
+		if !o.isIdempotent(method) {
+			return err
+		}
+
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		if grpc.Code(err) == codes.Unauthenticated {
+			return o.signInRequired(err)
+		}
+		return err
+	}
+}
+
+// streamRetryInterceptor refreshes tokens when a stream fails with codes.Unauthenticated, opening it
+// again if it is idempotent. Credentials are usually rejected once the request was sent, so server
+// streams are also retried if they fail before receiving any message.
+func streamRetryInterceptor(creds *tokenCreds, o *dialOptions) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if grpc.Code(err) == codes.Unauthenticated {
+			if err := creds.forceRefresh(ctx); err != nil {
+				return nil, o.signInRequired(err)
+			}
+
+			if !o.isIdempotent(method) {
+				return nil, err
+			}
+
+			stream, err = streamer(ctx, desc, cc, method, opts...)
+			if grpc.Code(err) == codes.Unauthenticated {
+				return nil, o.signInRequired(err)
+			}
+			return stream, err
+		}
+
+		if err != nil || desc.ClientStreams || !o.isIdempotent(method) {
+			return stream, err
+		}
+
+		return &retryStream{
+			ClientStream: stream,
+			ctx:          ctx,
+			desc:         desc,
+			cc:           cc,
+			method:       method,
+			streamer:     streamer,
+			opts:         opts,
+			creds:        creds,
+			options:      o,
+		}, nil
+	}
+}
+
+// retryStream is a server stream that is opened again, resending its request, if it fails with
+// codes.Unauthenticated before receiving any message.
+type retryStream struct {
+	grpc.ClientStream
+
+	ctx      context.Context
+	desc     *grpc.StreamDesc
+	cc       *grpc.ClientConn
+	method   string
+	streamer grpc.Streamer
+	opts     []grpc.CallOption
+	creds    *tokenCreds
+	options  *dialOptions
+
+	request  interface{}
+	received bool
+	retried  bool
+}
+
+func (s *retryStream) SendMsg(m interface{}) error {
+	s.request = m
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *retryStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if grpc.Code(err) != codes.Unauthenticated || s.received || s.retried || s.request == nil {
+		if err == nil {
+			s.received = true
+		}
+		return err
+	}
+	s.retried = true
+
+	if err := s.creds.forceRefresh(s.ctx); err != nil {
+		return s.options.signInRequired(err)
+	}
+
+	stream, err := s.streamer(s.ctx, s.desc, s.cc, s.method, s.opts...)
+	if err != nil {
+		return err
+	}
+
+	if err := stream.SendMsg(s.request); err != nil {
+		return err
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	s.ClientStream = stream
+
+	err = stream.RecvMsg(m)
+	if grpc.Code(err) == codes.Unauthenticated {
+		return s.options.signInRequired(err)
+	}
+
+	if err == nil {
+		s.received = true
+	}
+	return err
+}
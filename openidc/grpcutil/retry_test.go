@@ -0,0 +1,310 @@
+package grpcutil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/lift-plugins/auth/openidc/clients"
+	"github.com/lift-plugins/auth/openidc/discovery"
+	"github.com/lift-plugins/auth/openidc/oauth2"
+	"github.com/lift-plugins/auth/openidc/tokens"
+)
+
+// memStore keeps documents in memory, encoded as JSON like the file store does.
+type memStore map[string][]byte
+
+func (m memStore) Read(name string, v interface{}) error {
+	data, ok := m[name]
+	if !ok {
+		return os.ErrNotExist
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (m memStore) Write(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	m[name] = data
+	return nil
+}
+
+func (m memStore) Delete(name string) error {
+	delete(m, name)
+	return nil
+}
+
+// testCreds returns credentials of a service session whose tokens are refreshed by a fake provider.
+// If fail is true, the provider rejects the client credentials. It also returns the number of
+// refreshes and a function to stop the provider.
+func testCreds(t *testing.T, fail bool) (*tokenCreds, *int, func()) {
+	var refreshes int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshes++
+		if fail {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "invalid_client"}`)
+			return
+		}
+		fmt.Fprint(w, `{"access_token": "fresh", "token_type": "Bearer", "expires_in": 3600}`)
+	}))
+
+	s := make(memStore)
+	config := &discovery.ProviderConfig{Issuer: "https://id.example.com", TokenEndpoint: srv.URL}
+	if err := config.Write(s); err != nil {
+		t.Fatal(err)
+	}
+
+	client := new(clients.Client)
+	client.ClientId = "ci"
+	client.ClientSecret = "secret"
+	if err := client.WriteService(s); err != nil {
+		t.Fatal(err)
+	}
+
+	tks := &tokens.Tokens{Access: "stale", ServiceIdentity: true, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	if err := tks.Write(s); err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := accessTokenCreds(oauth2.NewContext(context.Background(), srv.Client()), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return creds, &refreshes, srv.Close
+}
+
+// idempotent returns connection settings retrying all methods if idempotent is true, or none otherwise.
+func idempotent(idempotent bool, options ...Option) *dialOptions {
+	isIdempotent := func(method string) bool { return idempotent }
+	return newDialOptions(append([]Option{WithIdempotent(isIdempotent)}, options...))
+}
+
+var errUnauthenticated = grpc.Errorf(codes.Unauthenticated, "token expired")
+
+func TestIsIdempotentDefault(t *testing.T) {
+	o := newDialOptions(nil)
+	for _, method := range []string{"/identity.Users/GetProfile", "/identity.Users/DeleteProfile"} {
+		if o.isIdempotent(method) {
+			t.Errorf("expected %q not to be retried by default", method)
+		}
+	}
+}
+
+func TestUnaryRetryInterceptor(t *testing.T) {
+	tests := []struct {
+		desc       string
+		idempotent bool
+		calls      int
+		err        bool
+	}{
+		{"idempotent", true, 2, false},
+		{"not idempotent", false, 1, true},
+	}
+
+	for _, tt := range tests {
+		creds, refreshes, closeProvider := testCreds(t, false)
+
+		var calls int
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			calls++
+			if calls == 1 {
+				return errUnauthenticated
+			}
+			return nil
+		}
+
+		err := unaryRetryInterceptor(creds, idempotent(tt.idempotent))(context.Background(), "/test.Service/Method", nil, nil, nil, invoker)
+		closeProvider()
+
+		if (err != nil) != tt.err || calls != tt.calls {
+			t.Errorf("%s: expected %d calls and error %t, got %d calls and %v", tt.desc, tt.calls, tt.err, calls, err)
+		}
+
+		if *refreshes != 1 || creds.tks.Access != "fresh" {
+			t.Errorf("%s: expected tokens to be refreshed, got %d refreshes", tt.desc, *refreshes)
+		}
+	}
+}
+
+func TestUnaryRetryInterceptorSignInRequired(t *testing.T) {
+	signInErr := errors.New("sign in again")
+	o := idempotent(true, WithSignInRequired(func(err error) error { return signInErr }))
+
+	// Tokens cannot be refreshed.
+	creds, _, closeProvider := testCreds(t, true)
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return errUnauthenticated
+	}
+
+	if err := unaryRetryInterceptor(creds, o)(context.Background(), "/test.Service/Method", nil, nil, nil, invoker); err != signInErr {
+		t.Errorf("expected sign in required error when tokens cannot be refreshed, got %v", err)
+	}
+	closeProvider()
+
+	// The server keeps rejecting refreshed tokens.
+	creds, _, closeProvider = testCreds(t, false)
+	defer closeProvider()
+
+	var calls int
+	invoker = func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		return errUnauthenticated
+	}
+
+	if err := unaryRetryInterceptor(creds, o)(context.Background(), "/test.Service/Method", nil, nil, nil, invoker); err != signInErr || calls != 2 {
+		t.Errorf("expected sign in required error after retrying, got %v after %d calls", err, calls)
+	}
+}
+
+// fakeStream is a client stream failing to receive messages with the given errors, in order.
+type fakeStream struct {
+	grpc.ClientStream
+	errs []error
+	sent []interface{}
+}
+
+func (s *fakeStream) SendMsg(m interface{}) error {
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+func (s *fakeStream) CloseSend() error {
+	return nil
+}
+
+func (s *fakeStream) RecvMsg(m interface{}) error {
+	if len(s.errs) == 0 {
+		return nil
+	}
+
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return err
+}
+
+// fakeStreamer returns a streamer opening the given streams, in order.
+func fakeStreamer(streams ...*fakeStream) (grpc.Streamer, *int) {
+	var opened int
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		stream := streams[opened]
+		opened++
+		return stream, nil
+	}, &opened
+}
+
+func TestStreamRetryInterceptor(t *testing.T) {
+	creds, refreshes, closeProvider := testCreds(t, false)
+	defer closeProvider()
+
+	first := &fakeStream{errs: []error{errUnauthenticated}}
+	second := new(fakeStream)
+	streamer, opened := fakeStreamer(first, second)
+
+	desc := &grpc.StreamDesc{ServerStreams: true}
+	stream, err := streamRetryInterceptor(creds, idempotent(true))(context.Background(), desc, nil, "/test.Service/Watch", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := stream.SendMsg("request"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := stream.RecvMsg(nil); err != nil {
+		t.Fatalf("expected the stream to be opened again, got %v", err)
+	}
+
+	if *opened != 2 || *refreshes != 1 {
+		t.Errorf("expected 2 streams and 1 refresh, got %d and %d", *opened, *refreshes)
+	}
+
+	if len(second.sent) != 1 || second.sent[0] != "request" {
+		t.Errorf("expected the request to be sent again, got %v", second.sent)
+	}
+}
+
+func TestRetryStreamAfterReceiving(t *testing.T) {
+	creds, refreshes, closeProvider := testCreds(t, false)
+	defer closeProvider()
+
+	// Credentials rejected once messages were received are not retried, since the server already
+	// acted upon the request.
+	stream := &fakeStream{errs: []error{nil, errUnauthenticated}}
+	streamer, opened := fakeStreamer(stream)
+
+	desc := &grpc.StreamDesc{ServerStreams: true}
+	s, err := streamRetryInterceptor(creds, idempotent(true))(context.Background(), desc, nil, "/test.Service/Watch", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SendMsg("request")
+
+	if err := s.RecvMsg(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RecvMsg(nil); grpc.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected the error to be returned, got %v", err)
+	}
+
+	if *opened != 1 || *refreshes != 0 {
+		t.Errorf("expected no retry, got %d streams and %d refreshes", *opened, *refreshes)
+	}
+}
+
+func TestStreamRetryInterceptorNotIdempotent(t *testing.T) {
+	creds, refreshes, closeProvider := testCreds(t, false)
+	defer closeProvider()
+
+	stream := &fakeStream{errs: []error{errUnauthenticated}}
+	streamer, opened := fakeStreamer(stream)
+
+	desc := &grpc.StreamDesc{ServerStreams: true}
+	s, err := streamRetryInterceptor(creds, idempotent(false))(context.Background(), desc, nil, "/test.Service/Delete", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s != stream {
+		t.Error("expected streams of methods that are not idempotent to be returned as is")
+	}
+
+	if err := s.RecvMsg(nil); grpc.Code(err) != codes.Unauthenticated || *opened != 1 || *refreshes != 0 {
+		t.Errorf("expected no retry, got %v with %d streams and %d refreshes", err, *opened, *refreshes)
+	}
+}
+
+func TestStreamRetryInterceptorOpenRejected(t *testing.T) {
+	creds, refreshes, closeProvider := testCreds(t, false)
+	defer closeProvider()
+
+	var opened int
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		opened++
+		if opened == 1 {
+			return nil, errUnauthenticated
+		}
+		return new(fakeStream), nil
+	}
+
+	desc := &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}
+	if _, err := streamRetryInterceptor(creds, idempotent(true))(context.Background(), desc, nil, "/test.Service/Sync", streamer); err != nil {
+		t.Fatal(err)
+	}
+
+	if opened != 2 || *refreshes != 1 {
+		t.Errorf("expected the stream to be opened again after refreshing, got %d streams and %d refreshes", opened, *refreshes)
+	}
+}
@@ -1,42 +1,55 @@
 package grpcutil
 
 import (
+	"net/http"
+	"sync"
+
 	"golang.org/x/net/context"
-	"google.golang.org/grpc/credentials"
 
 	"github.com/lift-plugins/auth/openidc/clients"
+	"github.com/lift-plugins/auth/openidc/oauth2"
+	"github.com/lift-plugins/auth/openidc/store"
 	"github.com/lift-plugins/auth/openidc/tokens"
 )
 
 type tokenCreds struct {
+	// mu guards tks, which is shared by concurrent calls.
+	mu           sync.Mutex
 	tks          *tokens.Tokens
+	store        store.Store
+	httpClient   *http.Client
 	clientID     string
 	clientSecret string
 }
 
-// accessTokenCreds returns an implementation of credentials.PerRPCCredentials. Used to
-// authenticate GRPC calls against the server. If there are any errors, no authentication
-// is sent to the gRPC server.
-func accessTokenCreds() (credentials.PerRPCCredentials, error) {
+// accessTokenCreds returns an implementation of credentials.PerRPCCredentials, backed by the tokens
+// stored in s. Used to authenticate GRPC calls against the server. If there are any errors, no
+// authentication is sent to the gRPC server.
+func accessTokenCreds(ctx context.Context, s store.Store) (*tokenCreds, error) {
 	tks := new(tokens.Tokens)
-	if err := tks.Read(); err != nil {
+	if err := tks.Read(s); err != nil {
 		return nil, err
 	}
 
-	client := new(clients.Client)
-	if err := client.Read(); err != nil {
+	client, err := clients.Load(s, tks.ServiceIdentity)
+	if err != nil {
 		return nil, err
 	}
 
 	return &tokenCreds{
 		tks:          tks,
+		store:        s,
+		httpClient:   oauth2.HTTPClient(ctx),
 		clientID:     client.ClientId,
 		clientSecret: client.ClientSecret,
 	}, nil
 }
 
 func (c *tokenCreds) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
-	if err := c.tks.RefreshToken(c.clientID, c.clientSecret); err != nil {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.tks.RefreshToken(oauth2.NewContext(ctx, c.httpClient), c.store, c.clientID, c.clientSecret); err != nil {
 		return nil, err
 	}
 
@@ -45,6 +58,14 @@ func (c *tokenCreds) GetRequestMetadata(ctx context.Context, uri ...string) (map
 	}, nil
 }
 
+// forceRefresh refreshes tokens even if they did not expire, after the server rejected them.
+func (c *tokenCreds) forceRefresh(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.tks.ForceRefresh(oauth2.NewContext(ctx, c.httpClient), c.store, c.clientID, c.clientSecret)
+}
+
 func (c *tokenCreds) RequireTransportSecurity() bool {
 	return true
 }
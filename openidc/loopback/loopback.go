@@ -0,0 +1,101 @@
+package loopback
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const responsePage = `<!DOCTYPE html>
+<html>
+<head><title>Lift</title></head>
+<body><p>%s You can close this window and return to your terminal.</p></body>
+</html>
+`
+
+// Server is a short-lived HTTP server listening on the loopback interface, used to
+// receive a single OAuth2 redirect from the user's browser.
+// https://tools.ietf.org/html/rfc8252#section-7.3
+type Server struct {
+	srv    *http.Server
+	state  string
+	once   sync.Once
+	params chan url.Values
+}
+
+// Listen starts listening on the host, port and path of the given redirect URI. Only
+// loopback redirect URIs are allowed. Redirects whose state parameter does not match state
+// are answered with an error page and ignored, so that forged or stale redirects cannot
+// complete or abort the flow.
+func Listen(redirectURI, state string) (*Server, error) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed parsing redirect URI %q", redirectURI)
+	}
+
+	host := u.Hostname()
+	if host != "localhost" && !net.ParseIP(host).IsLoopback() {
+		return nil, fmt.Errorf("redirect URI %q is not a loopback address", redirectURI)
+	}
+
+	ln, err := net.Listen("tcp", u.Host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed listening on %q", u.Host)
+	}
+
+	s := &Server{
+		state:  state,
+		params: make(chan url.Values, 1),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(u.Path, s.handle)
+	s.srv = &http.Server{Handler: mux}
+
+	go s.srv.Serve(ln)
+
+	return s, nil
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if params.Get("state") != s.state {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, responsePage, "This response does not match the request sent by Lift, so it was ignored.")
+		return
+	}
+
+	if params.Get("error") != "" {
+		fmt.Fprintf(w, responsePage, "Sign in failed.")
+	} else {
+		fmt.Fprintf(w, responsePage, "Done.")
+	}
+
+	// Only the first redirect is taken into account.
+	s.once.Do(func() {
+		s.params <- params
+	})
+}
+
+// Wait blocks until the redirect is received or the context is done, returning
+// the redirect query parameters.
+func (s *Server) Wait(ctx context.Context) (url.Values, error) {
+	select {
+	case params := <-s.params:
+		return params, nil
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "timed out waiting for browser redirect")
+	}
+}
+
+// Close shuts down the server.
+func (s *Server) Close() error {
+	return s.srv.Close()
+}
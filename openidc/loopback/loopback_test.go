@@ -0,0 +1,65 @@
+package loopback
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// freeAddress returns a loopback address with a port that is free to listen on.
+func freeAddress(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestStateMismatch(t *testing.T) {
+	addr := freeAddress(t)
+	s, err := Listen("http://"+addr+"/callback", "expected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	resp, err := http.Get("http://" + addr + "/callback?state=forged&code=code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected an error page for a mismatching state, got status %d", resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := s.Wait(ctx); err == nil {
+		t.Fatal("redirects with a mismatching state should be ignored")
+	}
+
+	resp, err = http.Get("http://" + addr + "/callback?state=expected&code=code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	params, err := s.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if params.Get("code") != "code" {
+		t.Errorf("expected redirect parameters, got %v", params)
+	}
+}
+
+func TestListenRejectsNonLoopback(t *testing.T) {
+	if _, err := Listen("http://example.com:9999/callback", "state"); err == nil {
+		t.Error("expected error for a non loopback redirect URI")
+	}
+}
@@ -1,6 +1,7 @@
 package oauth2
 
 import (
+	"context"
 	"net/http"
 	"time"
 )
@@ -21,3 +22,25 @@ func init() {
 		},
 	}
 }
+
+// httpClientKey is the context key of the HTTP client carried by a context.
+type httpClientKey struct{}
+
+// NewContext returns a copy of ctx carrying client, which is used in place of Client for requests
+// made with the returned context. It mirrors the HTTPClient context key of golang.org/x/oauth2.
+func NewContext(ctx context.Context, client *http.Client) context.Context {
+	return context.WithValue(ctx, httpClientKey{}, client)
+}
+
+// HTTPClient returns the HTTP client carried by ctx, or Client if there is none.
+func HTTPClient(ctx context.Context) *http.Client {
+	if client, ok := ctx.Value(httpClientKey{}).(*http.Client); ok && client != nil {
+		return client
+	}
+	return Client
+}
+
+// Do sends req with the HTTP client carried by ctx. The request is canceled along with ctx.
+func Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	return HTTPClient(ctx).Do(req.WithContext(ctx))
+}
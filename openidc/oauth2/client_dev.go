@@ -8,7 +8,9 @@ import (
 	"net/http"
 )
 
-const tlsCert = `
+// DevCert is the development server self-signed public certificate. It is only compiled in, and
+// trusted, when the "dev" build tag is used during compilation, since its private key is public.
+const DevCert = `
 -----BEGIN CERTIFICATE-----
 MIIDUzCCAtmgAwIBAgIJAKTf/aVGhWkYMAkGByqGSM49BAEwgZExCzAJBgNVBAYT
 AlVTMREwDwYDVQQIEwhOZXcgWW9yazERMA8GA1UEBxMITmV3IFlvcmsxFzAVBgNV
@@ -31,17 +33,16 @@ o9FuJqdUS5o9Rgii55nFhNdzQvT/p/ANGHBCfQyUNtAjPp92KvXC
 -----END CERTIFICATE-----
 `
 
-// Injects self-sign TLS certificate to aid development.
+// Injects self-sign TLS certificate to aid development. It runs before any request is made, so
+// the transport is never replaced while in use.
 func init() {
 	roots := x509.NewCertPool()
-	ok := roots.AppendCertsFromPEM([]byte(tlsCert))
+	ok := roots.AppendCertsFromPEM([]byte(DevCert))
 	if !ok {
 		panic("failed to parse root certificate")
 	}
 
-	transport := &http.Transport{
+	Client.Transport = &http.Transport{
 		TLSClientConfig: &tls.Config{RootCAs: roots},
 	}
-
-	Client.Transport = transport
 }
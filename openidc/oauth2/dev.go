@@ -0,0 +1,37 @@
+package oauth2
+
+import (
+	"os"
+	"sync"
+)
+
+var dev struct {
+	sync.Mutex
+	enabled bool
+}
+
+func init() {
+	switch os.Getenv("LIFT_AUTH_DEV") {
+	case "1", "true":
+		EnableDevMode()
+	}
+}
+
+// EnableDevMode validates provider configuration leniently, to aid development against local
+// identity providers. It can also be enabled by setting LIFT_AUTH_DEV=1. It does not change which
+// certificates are trusted: the development server certificate is only trusted by binaries built
+// with the "dev" build tag.
+func EnableDevMode() {
+	dev.Lock()
+	defer dev.Unlock()
+
+	dev.enabled = true
+}
+
+// DevMode returns whether dev mode is enabled.
+func DevMode() bool {
+	dev.Lock()
+	defer dev.Unlock()
+
+	return dev.enabled
+}
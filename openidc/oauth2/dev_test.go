@@ -0,0 +1,17 @@
+// +build !dev
+
+package oauth2
+
+import "testing"
+
+func TestEnableDevModeKeepsTransport(t *testing.T) {
+	EnableDevMode()
+	if !DevMode() {
+		t.Error("expected dev mode to be enabled")
+	}
+
+	// Only binaries built with the dev tag trust the development certificate.
+	if Client.Transport != nil {
+		t.Errorf("expected the default transport, got %T", Client.Transport)
+	}
+}
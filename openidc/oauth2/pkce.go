@@ -0,0 +1,35 @@
+package oauth2
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/pkg/errors"
+)
+
+// PKCE holds a Proof Key for Code Exchange verifier and its S256 challenge, as
+// specified in https://tools.ietf.org/html/rfc7636
+type PKCE struct {
+	Verifier        string
+	Challenge       string
+	ChallengeMethod string
+}
+
+// NewPKCE generates a new high-entropy code verifier and derives its S256 challenge.
+func NewPKCE() (*PKCE, error) {
+	// 32 octets result in a 43 characters verifier, the minimum length allowed by the spec.
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errors.Wrap(err, "failed generating PKCE code verifier")
+	}
+
+	verifier := base64.RawURLEncoding.EncodeToString(b)
+	sum := sha256.Sum256([]byte(verifier))
+
+	return &PKCE{
+		Verifier:        verifier,
+		Challenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+		ChallengeMethod: "S256",
+	}, nil
+}
@@ -0,0 +1,27 @@
+package oauth2
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+)
+
+func TestNewPKCE(t *testing.T) {
+	pkce, err := NewPKCE()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if len(pkce.Verifier) < 43 || len(pkce.Verifier) > 128 {
+		t.Errorf("verifier length out of bounds: %d", len(pkce.Verifier))
+	}
+
+	sum := sha256.Sum256([]byte(pkce.Verifier))
+	if challenge := base64.RawURLEncoding.EncodeToString(sum[:]); challenge != pkce.Challenge {
+		t.Errorf("challenge does not match verifier: %s != %s", pkce.Challenge, challenge)
+	}
+
+	if pkce.ChallengeMethod != "S256" {
+		t.Errorf("unexpected challenge method %q", pkce.ChallengeMethod)
+	}
+}
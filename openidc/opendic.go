@@ -9,20 +9,30 @@ import (
 	"github.com/hooklift/lift/ui"
 	"github.com/lift-plugins/auth/openidc/clients"
 	"github.com/lift-plugins/auth/openidc/grpcutil"
+	"github.com/lift-plugins/auth/openidc/store"
+	"github.com/lift-plugins/auth/openidc/tokens"
 )
 
+// RedirectURI is the loopback address registered for receiving authorization responses
+// from the user's browser. It is also used as post logout redirect URI, to confirm the user
+// was signed out from the provider's browser session.
+const RedirectURI = "http://localhost:9999/callback"
+
 // RegisterClient creates a lift CLI client for the account identified by username and password.
-func RegisterClient(ctx context.Context, address, username, password string) (*clients.Client, error) {
+// If username is empty, the client is registered anonymously, which is what login flows
+// that never see the user's password, such as the device authorization grant, rely on. The client
+// is kept in s, and only registered if s does not have one yet.
+func RegisterClient(ctx context.Context, s store.Store, address, username, password string) (*clients.Client, error) {
 	clientApp := new(clients.Client)
 	var err error
-	if err = clientApp.Read(); err == nil {
+	if err = clientApp.Read(s); err == nil {
 		return clientApp, nil
 	}
 
 	ui.Debug("Client not found: %+v", err)
 	ui.Debug("Creating a new client...")
 
-	grpcConn, err := grpcutil.Connection(address, "lift-auth", username, password)
+	grpcConn, err := grpcutil.Dial(ctx, s, address, "lift-auth", grpcutil.WithBasicAuth(username, password))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed connecting to openid provider.")
 	}
@@ -30,17 +40,24 @@ func RegisterClient(ctx context.Context, address, username, password string) (*c
 
 	clientService := api.NewAppsClient(grpcConn)
 	req := &api.RegisterApp{
-		ClientName:      "Lift CLI",
-		ClientUri:       "https://www.hooklift.io/lift?user=" + username,
-		ApplicationType: "native",
-		RedirectUris:    []string{"http://localhost:9999/callback"},
-		ResponseTypes:   []string{"token", "id_token"},
-		GrantTypes:      []string{"password", "refresh_token"},
-		LogoUri:         "https://avatars1.githubusercontent.com/u/22415297?v=3&s=200",
-		Contacts:        []string{"eng@hooklift.io"},
-		PolicyUri:       "https://www.hooklift.io/policy/privacy",
-		TosUri:          "https://www.hooklift.io/policy/tos",
-		IdTokenSignedResponseAlg: "ES256",
+		ClientName:             "Lift CLI",
+		ClientUri:              "https://www.hooklift.io/lift?user=" + username,
+		ApplicationType:        "native",
+		RedirectUris:           []string{RedirectURI},
+		PostLogoutRedirectUris: []string{RedirectURI},
+		ResponseTypes:          []string{"token", "id_token", "code"},
+		GrantTypes: []string{
+			"password",
+			"refresh_token",
+			"authorization_code",
+			"urn:ietf:params:oauth:grant-type:device_code",
+			"urn:ietf:params:oauth:grant-type:token-exchange",
+		},
+		LogoUri:                  "https://avatars1.githubusercontent.com/u/22415297?v=3&s=200",
+		Contacts:                 []string{"eng@hooklift.io"},
+		PolicyUri:                "https://www.hooklift.io/policy/privacy",
+		TosUri:                   "https://www.hooklift.io/policy/tos",
+		IdTokenSignedResponseAlg: tokens.IDTokenSigningAlg,
 	}
 
 	res, err := clientService.Register(ctx, req)
@@ -49,7 +66,7 @@ func RegisterClient(ctx context.Context, address, username, password string) (*c
 	}
 
 	clientApp.RegisterApp = *res
-	if err := clientApp.Write(); err != nil {
+	if err := clientApp.Write(s); err != nil {
 		return nil, err
 	}
 
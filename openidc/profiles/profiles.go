@@ -0,0 +1,232 @@
+package profiles
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hooklift/lift/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultName is the profile used when none is selected.
+	DefaultName = "default"
+	// EnvVar is the environment variable used to select a profile.
+	EnvVar = "LIFT_AUTH_PROFILE"
+)
+
+var (
+	rootDir     = filepath.Join(config.WorkDir, "auth")
+	profilesDir = filepath.Join(rootDir, "profiles")
+	currentPath = filepath.Join(rootDir, "profile")
+
+	// legacyDir is where files were stored before profiles existed.
+	legacyDir = config.WorkDir
+
+	// legacyFiles are the files stored directly under legacyDir before profiles existed.
+	legacyFiles = []string{"tokens.json", "client.json", "openidc.json", "jwks.json"}
+
+	validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+	mu       sync.Mutex
+	selected string
+	migrate  sync.Once
+)
+
+// Validate checks that name can be used as a profile name.
+func Validate(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q, only letters, numbers, dots, dashes and underscores are allowed", name)
+	}
+	return nil
+}
+
+// Select sets the profile used by this process, taking precedence over the
+// LIFT_AUTH_PROFILE environment variable and the profile set with Use. An empty
+// name clears the selection.
+func Select(name string) error {
+	if name != "" {
+		if err := Validate(name); err != nil {
+			return err
+		}
+	}
+
+	mu.Lock()
+	selected = name
+	mu.Unlock()
+	return nil
+}
+
+// Selected returns the profile selected for this process, if any.
+func Selected() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return selected
+}
+
+// Current returns the name of the active profile. In order of precedence, it is the
+// profile selected for this process, the one in LIFT_AUTH_PROFILE, or the one set with Use.
+// Falls back to the default profile.
+func Current() string {
+	mu.Lock()
+	name := selected
+	mu.Unlock()
+
+	if name != "" {
+		return name
+	}
+
+	if name := os.Getenv(EnvVar); Validate(name) == nil {
+		return name
+	}
+
+	data, err := ioutil.ReadFile(currentPath)
+	if err == nil {
+		if name := strings.TrimSpace(string(data)); Validate(name) == nil {
+			return name
+		}
+	}
+
+	return DefaultName
+}
+
+// Dir returns the directory where data for the given profile is stored. Files of installs that
+// predate profiles are migrated to the default profile first.
+func Dir(name string) string {
+	migrate.Do(migrateLegacy)
+	return dir(name)
+}
+
+// dir returns the directory of the given profile, without migrating legacy files.
+func dir(name string) string {
+	return filepath.Join(profilesDir, name)
+}
+
+// Path returns the path of a file stored in the active profile. The profile directory is not
+// created, it is up to writers to create it.
+func Path(file string) string {
+	return filepath.Join(Dir(Current()), file)
+}
+
+// List returns the names of all the stored profiles, sorted alphabetically.
+func List() ([]string, error) {
+	migrate.Do(migrateLegacy)
+
+	entries, err := ioutil.ReadDir(profilesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed listing profiles at %q", profilesDir)
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() && Validate(e.Name()) == nil {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Files returns the names of the files stored for the given profile, sorted alphabetically.
+func Files(name string) ([]string, error) {
+	if err := Validate(name); err != nil {
+		return nil, err
+	}
+
+	dir := Dir(name)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("profile %q does not exist", name)
+		}
+		return nil, errors.Wrapf(err, "failed listing files of profile %q", name)
+	}
+
+	var files []string
+	for _, e := range entries {
+		if e.Mode().IsRegular() {
+			files = append(files, e.Name())
+		}
+	}
+	return files, nil
+}
+
+// Use persists name as the profile to use when none is selected explicitly. Except for the
+// default profile, the profile must exist.
+func Use(name string) error {
+	if err := Validate(name); err != nil {
+		return err
+	}
+
+	if name != DefaultName {
+		if _, err := os.Stat(Dir(name)); err != nil {
+			return fmt.Errorf("profile %q does not exist", name)
+		}
+	}
+
+	if err := os.MkdirAll(rootDir, os.FileMode(0700)); err != nil {
+		return errors.Wrapf(err, "failed creating %q", rootDir)
+	}
+
+	if err := ioutil.WriteFile(currentPath, []byte(name+"\n"), os.FileMode(0600)); err != nil {
+		return errors.Wrapf(err, "failed writing current profile to %q", currentPath)
+	}
+	return nil
+}
+
+// Delete removes the directory of the given profile. If it was the profile in use, the default
+// profile is used from then on. Secrets kept outside the directory, such as in a keyring, are
+// not removed, it is up to callers to delete them first.
+func Delete(name string) error {
+	if err := Validate(name); err != nil {
+		return err
+	}
+
+	dir := Dir(name)
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("profile %q does not exist", name)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return errors.Wrapf(err, "failed deleting profile %q", name)
+	}
+
+	data, err := ioutil.ReadFile(currentPath)
+	if err == nil && strings.TrimSpace(string(data)) == name {
+		if err := os.Remove(currentPath); err != nil {
+			return errors.Wrapf(err, "failed resetting current profile")
+		}
+	}
+	return nil
+}
+
+// migrateLegacy moves files from installs that predate profiles into the default profile.
+// Errors are ignored, leaving legacy files in place.
+func migrateLegacy() {
+	defaultDir := dir(DefaultName)
+	for _, file := range legacyFiles {
+		oldPath := filepath.Join(legacyDir, file)
+		if _, err := os.Stat(oldPath); err != nil {
+			continue
+		}
+
+		newPath := filepath.Join(defaultDir, file)
+		if _, err := os.Stat(newPath); err == nil {
+			continue
+		}
+
+		if err := os.MkdirAll(defaultDir, os.FileMode(0700)); err != nil {
+			return
+		}
+		os.Rename(oldPath, newPath)
+	}
+}
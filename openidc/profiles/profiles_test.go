@@ -0,0 +1,165 @@
+package profiles
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// useTempDir points profiles to a temporary directory, clearing any selected profile. The returned
+// function restores the previous state.
+func useTempDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prevRoot, prevProfiles, prevCurrent, prevLegacy := rootDir, profilesDir, currentPath, legacyDir
+	prevSelected, prevEnv := Selected(), os.Getenv(EnvVar)
+
+	rootDir = filepath.Join(dir, "auth")
+	profilesDir = filepath.Join(dir, "auth", "profiles")
+	currentPath = filepath.Join(dir, "auth", "profile")
+	legacyDir = dir
+	migrate = sync.Once{}
+	Select("")
+	os.Unsetenv(EnvVar)
+
+	return func() {
+		rootDir, profilesDir, currentPath, legacyDir = prevRoot, prevProfiles, prevCurrent, prevLegacy
+		Select(prevSelected)
+		os.Setenv(EnvVar, prevEnv)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := []string{"default", "work", "staging-1", "me_at.corp"}
+	for _, name := range valid {
+		if err := Validate(name); err != nil {
+			t.Errorf("expected %q to be valid: %v", name, err)
+		}
+	}
+
+	invalid := []string{"", ".", "..", "../etc", "a/b", ".hidden", "with space"}
+	for _, name := range invalid {
+		if err := Validate(name); err == nil {
+			t.Errorf("expected %q to be invalid", name)
+		}
+	}
+}
+
+func TestPathDoesNotCreateProfile(t *testing.T) {
+	defer useTempDir(t)()
+
+	path := Path("tokens.json")
+	if path != filepath.Join(profilesDir, DefaultName, "tokens.json") {
+		t.Errorf("unexpected path %q", path)
+	}
+
+	if _, err := os.Stat(filepath.Dir(path)); !os.IsNotExist(err) {
+		t.Error("reading a path should not create the profile directory")
+	}
+}
+
+func TestCurrent(t *testing.T) {
+	defer useTempDir(t)()
+
+	if name := Current(); name != DefaultName {
+		t.Errorf("expected default profile, got %q", name)
+	}
+
+	os.MkdirAll(Dir("work"), 0700)
+	if err := Use("work"); err != nil {
+		t.Fatal(err)
+	}
+
+	if name := Current(); name != "work" {
+		t.Errorf("expected profile set with Use, got %q", name)
+	}
+
+	os.Setenv(EnvVar, "env")
+	if name := Current(); name != "env" {
+		t.Errorf("expected profile from %s, got %q", EnvVar, name)
+	}
+
+	Select("selected")
+	if name := Current(); name != "selected" {
+		t.Errorf("expected selected profile, got %q", name)
+	}
+}
+
+func TestUse(t *testing.T) {
+	defer useTempDir(t)()
+
+	if err := Use("missing"); err == nil {
+		t.Error("expected error using a profile that does not exist")
+	}
+
+	if err := Use(DefaultName); err != nil {
+		t.Errorf("the default profile can always be used: %v", err)
+	}
+
+	if err := Use("../escape"); err == nil {
+		t.Error("expected error for an invalid name")
+	}
+}
+
+func TestListAndDelete(t *testing.T) {
+	defer useTempDir(t)()
+
+	names, err := List()
+	if err != nil || names != nil {
+		t.Fatalf("expected no profiles, got %v, %v", names, err)
+	}
+
+	for _, name := range []string{"work", DefaultName, ".hidden"} {
+		os.MkdirAll(filepath.Join(profilesDir, name), 0700)
+	}
+
+	names, err = List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(names, []string{DefaultName, "work"}) {
+		t.Errorf("unexpected profiles %v", names)
+	}
+
+	if err := Use("work"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Delete("work"); err != nil {
+		t.Fatal(err)
+	}
+
+	if name := Current(); name != DefaultName {
+		t.Errorf("expected default profile after deleting the one in use, got %q", name)
+	}
+
+	if err := Delete("work"); err == nil {
+		t.Error("expected error deleting a profile that does not exist")
+	}
+}
+
+func TestDirMigratesLegacyFiles(t *testing.T) {
+	defer useTempDir(t)()
+
+	if err := ioutil.WriteFile(filepath.Join(legacyDir, "tokens.json"), []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stores of an explicit profile only resolve paths through Dir.
+	path := filepath.Join(Dir(DefaultName), "tokens.json")
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected legacy tokens to be migrated to %q: %v", path, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(legacyDir, "tokens.json")); !os.IsNotExist(err) {
+		t.Errorf("expected legacy tokens to be moved, got %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// sealedPrefix marks secret values sealed by the encrypted store.
+	sealedPrefix = "sealed:v1:"
+	saltSize     = 16
+	keySize      = 32
+)
+
+// Encrypted is a store that seals secret values, as returned by Secrets, using AES-256-GCM
+// before handing documents over to an underlying store. Each value is sealed with a key
+// derived from a passphrase, using scrypt and a random salt.
+type Encrypted struct {
+	store      Store
+	passphrase []byte
+	err        error
+
+	// mu guards keys and salt.
+	mu sync.Mutex
+	// keys caches the keys derived from the passphrase by salt, since scrypt is deliberately slow.
+	keys map[string][]byte
+	// salt is used to seal all the values written by this store, so that a single key is derived for them.
+	salt []byte
+}
+
+// NewEncrypted returns an encrypted store on top of s, deriving keys from passphrase.
+func NewEncrypted(s Store, passphrase []byte) *Encrypted {
+	return &Encrypted{
+		store:      s,
+		passphrase: passphrase,
+	}
+}
+
+// EncryptedFromEnv returns an encrypted store on top of s, taking the passphrase from
+// LIFT_AUTH_PASSPHRASE or the contents of the file at LIFT_AUTH_KEY_FILE.
+// If neither is set, reading or writing from the store fails.
+func EncryptedFromEnv(s Store) *Encrypted {
+	e := NewEncrypted(s, []byte(os.Getenv("LIFT_AUTH_PASSPHRASE")))
+	if keyFile := os.Getenv("LIFT_AUTH_KEY_FILE"); keyFile != "" {
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			e.err = errors.Wrapf(err, "failed reading key file at %q", keyFile)
+		}
+		e.passphrase = bytes.TrimSpace(key)
+	}
+
+	if e.err == nil && len(e.passphrase) == 0 {
+		e.err = errors.New("encrypted store requires LIFT_AUTH_PASSPHRASE or LIFT_AUTH_KEY_FILE to be set")
+	}
+	return e
+}
+
+// Read loads the document stored under name into v, opening its sealed secrets.
+// Secrets that were stored in plaintext are returned as is, and get sealed on the next write.
+func (e *Encrypted) Read(name string, v interface{}) error {
+	if e.err != nil {
+		return e.err
+	}
+
+	if err := e.store.Read(name, v); err != nil {
+		return err
+	}
+
+	secrets, ok := v.(Secrets)
+	if !ok {
+		return nil
+	}
+
+	for key, field := range secrets.Secrets() {
+		if !strings.HasPrefix(*field, sealedPrefix) {
+			continue
+		}
+
+		value, err := e.open(key, *field)
+		if err != nil {
+			return errors.Wrapf(err, "failed opening %s in %q", key, name)
+		}
+		*field = value
+	}
+	return nil
+}
+
+// Write seals the secrets of v and stores it under name. v is left unchanged.
+func (e *Encrypted) Write(name string, v interface{}) error {
+	if e.err != nil {
+		return e.err
+	}
+
+	secrets, ok := v.(Secrets)
+	if !ok {
+		return e.store.Write(name, v)
+	}
+
+	fields := secrets.Secrets()
+	plain := make(map[string]string, len(fields))
+	defer func() {
+		for key, value := range plain {
+			*fields[key] = value
+		}
+	}()
+
+	for key, field := range fields {
+		if *field == "" {
+			continue
+		}
+
+		sealed, err := e.seal(key, *field)
+		if err != nil {
+			return errors.Wrapf(err, "failed sealing %s in %q", key, name)
+		}
+		plain[key] = *field
+		*field = sealed
+	}
+
+	return e.store.Write(name, v)
+}
+
+// Delete removes the document stored under name.
+func (e *Encrypted) Delete(name string) error {
+	return e.store.Delete(name)
+}
+
+// Lock takes the lock called name on the underlying store.
+func (e *Encrypted) Lock(name string) (func(), error) {
+	return Lock(e.store, name)
+}
+
+// seal encrypts value, binding it to key so that sealed values cannot be swapped.
+func (e *Encrypted) seal(key, value string) (string, error) {
+	salt, err := e.sealingSalt()
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := e.aead(salt)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	out := append(append([]byte(nil), salt...), nonce...)
+	out = aead.Seal(out, nonce, []byte(value), []byte(key))
+	return sealedPrefix + base64.RawURLEncoding.EncodeToString(out), nil
+}
+
+// open decrypts a value previously sealed under key.
+func (e *Encrypted) open(key, sealed string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(sealed, sealedPrefix))
+	if err != nil {
+		return "", errors.Wrap(err, "failed decoding sealed value")
+	}
+
+	if len(data) < saltSize {
+		return "", errors.New("sealed value is too short")
+	}
+
+	aead, err := e.aead(data[:saltSize])
+	if err != nil {
+		return "", err
+	}
+
+	data = data[saltSize:]
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("sealed value is too short")
+	}
+
+	value, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(key))
+	if err != nil {
+		return "", errors.New("wrong passphrase or tampered value")
+	}
+	return string(value), nil
+}
+
+// sealingSalt returns the salt used to seal values, generating it on first use. Nonces are random,
+// so values sealed with the same key remain distinct.
+func (e *Encrypted) sealingSalt() ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.salt == nil {
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		e.salt = salt
+	}
+	return e.salt, nil
+}
+
+// key derives a key from the passphrase and salt, caching it.
+func (e *Encrypted) key(salt []byte) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if key, ok := e.keys[string(salt)]; ok {
+		return key, nil
+	}
+
+	key, err := scrypt.Key(e.passphrase, salt, 1<<15, 8, 1, keySize)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed deriving encryption key")
+	}
+
+	if e.keys == nil {
+		e.keys = make(map[string][]byte)
+	}
+	e.keys[string(salt)] = key
+	return key, nil
+}
+
+// aead returns an AES-GCM cipher for the key derived from the passphrase and salt.
+func (e *Encrypted) aead(salt []byte) (cipher.AEAD, error) {
+	key, err := e.key(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
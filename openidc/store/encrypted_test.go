@@ -0,0 +1,106 @@
+package store
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// memory is an in-memory store used to inspect what gets persisted.
+type memory map[string][]byte
+
+func (m memory) Read(name string, v interface{}) error {
+	return json.Unmarshal(m[name], v)
+}
+
+func (m memory) Write(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	m[name] = data
+	return err
+}
+
+func (m memory) Delete(name string) error {
+	delete(m, name)
+	return nil
+}
+
+type doc struct {
+	Public string `json:"public"`
+	Secret string `json:"secret"`
+}
+
+func (d *doc) Secrets() map[string]*string {
+	return map[string]*string{"secret": &d.Secret}
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	mem := make(memory)
+	s := NewEncrypted(mem, []byte("passphrase"))
+
+	in := &doc{Public: "issuer", Secret: "refresh-token"}
+	if err := s.Write("doc.json", in); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if in.Secret != "refresh-token" {
+		t.Errorf("document was modified by write: %q", in.Secret)
+	}
+
+	stored := new(doc)
+	if err := mem.Read("doc.json", stored); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if stored.Public != "issuer" {
+		t.Errorf("non-secret value should be stored in plaintext, got %q", stored.Public)
+	}
+
+	if !strings.HasPrefix(stored.Secret, sealedPrefix) || strings.Contains(stored.Secret, "refresh-token") {
+		t.Errorf("secret value was not sealed: %q", stored.Secret)
+	}
+
+	out := new(doc)
+	if err := s.Read("doc.json", out); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if *out != *in {
+		t.Errorf("expected %+v, got %+v", in, out)
+	}
+
+	wrong := NewEncrypted(mem, []byte("wrong"))
+	if err := wrong.Read("doc.json", new(doc)); err == nil {
+		t.Error("expected error when opening with the wrong passphrase")
+	}
+}
+
+func TestEncryptedCachesKeys(t *testing.T) {
+	mem := make(memory)
+	s := NewEncrypted(mem, []byte("passphrase"))
+
+	for _, secret := range []string{"first", "second"} {
+		if err := s.Write(secret+".json", &doc{Secret: secret}); err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		out := new(doc)
+		if err := s.Read(secret+".json", out); err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		if out.Secret != secret {
+			t.Errorf("expected %q, got %q", secret, out.Secret)
+		}
+	}
+
+	if len(s.keys) != 1 {
+		t.Errorf("expected a single key to be derived, got %d", len(s.keys))
+	}
+
+	first, second := new(doc), new(doc)
+	mem.Read("first.json", first)
+	mem.Read("second.json", second)
+	if first.Secret == second.Secret {
+		t.Error("sealed values should differ")
+	}
+}
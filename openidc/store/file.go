@@ -0,0 +1,111 @@
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/lift-plugins/auth/openidc/filelock"
+	"github.com/lift-plugins/auth/openidc/profiles"
+	"github.com/pkg/errors"
+)
+
+// File stores documents as plaintext JSON files in a profile directory.
+type File struct {
+	// Profile is the name of the profile whose directory holds the files. Defaults to the active profile.
+	Profile string
+}
+
+// path returns the path of file name.
+func (f *File) path(name string) string {
+	if f.Profile == "" {
+		return profiles.Path(name)
+	}
+	return filepath.Join(profiles.Dir(f.Profile), name)
+}
+
+// Read loads the document stored in file name into v.
+func (f *File) Read(name string, v interface{}) error {
+	path := f.path(name)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed reading %q", path)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.Wrapf(err, "failed unmarshaling %q", path)
+	}
+	return nil
+}
+
+// Write stores v in file name. The file is replaced atomically, so that readers and crashes never
+// observe a partially written file.
+func (f *File) Write(name string, v interface{}) error {
+	path := f.path(name)
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return errors.Wrapf(err, "failed marshaling %q", name)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0700)); err != nil {
+		return errors.Wrapf(err, "failed creating %q", filepath.Dir(path))
+	}
+
+	if err := writeFile(path, data, os.FileMode(0600)); err != nil {
+		return errors.Wrapf(err, "failed writing %q", path)
+	}
+	return nil
+}
+
+// writeFile writes data to a temporary file in the same directory as path, and renames it
+// to path once it has been flushed to disk.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	// Cleans up the temporary file if anything fails before the rename.
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Delete removes file name.
+func (f *File) Delete(name string) error {
+	return os.Remove(f.path(name))
+}
+
+// Lock takes an exclusive lock on file name, shared with other processes.
+func (f *File) Lock(name string) (func(), error) {
+	path := f.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0700)); err != nil {
+		return nil, errors.Wrapf(err, "failed creating %q", filepath.Dir(path))
+	}
+
+	lock, err := filelock.Acquire(path)
+	if err != nil {
+		return nil, err
+	}
+	return func() { lock.Release() }, nil
+}
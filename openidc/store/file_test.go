@@ -0,0 +1,41 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tokens.json")
+	for _, data := range []string{"first", "second"} {
+		if err := writeFile(path, []byte(data), os.FileMode(0600)); err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+
+		if string(got) != data {
+			t.Errorf("expected %q, got %q", data, got)
+		}
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if len(entries) != 1 {
+		t.Errorf("expected temporary files to be cleaned up, found %d files", len(entries))
+	}
+}
@@ -0,0 +1,310 @@
+// +build linux
+
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/godbus/dbus"
+	"github.com/pkg/errors"
+
+	"github.com/lift-plugins/auth/openidc/profiles"
+)
+
+// Freedesktop Secret Service D-Bus API names.
+// https://specifications.freedesktop.org/secret-service/
+const (
+	secretsDest       = "org.freedesktop.secrets"
+	secretsPath       = dbus.ObjectPath("/org/freedesktop/secrets")
+	defaultCollection = dbus.ObjectPath("/org/freedesktop/secrets/aliases/default")
+	serviceIface      = "org.freedesktop.Secret.Service"
+	collectionIface   = "org.freedesktop.Secret.Collection"
+	itemIface         = "org.freedesktop.Secret.Item"
+	promptIface       = "org.freedesktop.Secret.Prompt"
+	noPrompt          = dbus.ObjectPath("/")
+
+	// keyringApp identifies the secrets stored by this plugin.
+	keyringApp = "lift-auth"
+
+	// promptTimeout is how long we wait for the user to unlock the keyring.
+	promptTimeout = 2 * time.Minute
+)
+
+// secret is the Secret Service API representation of a secret value.
+type secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// Keyring is a store that keeps secret values, as returned by Secrets, in the user's keyring
+// through the Freedesktop Secret Service API. Non-secret data is handed over to an
+// underlying store.
+type Keyring struct {
+	store   Store
+	conn    *dbus.Conn
+	profile string
+}
+
+// NewKeyring returns a keyring store on top of s, keeping the secrets of the given profile, or of
+// the active profile if it is empty. If there is no session bus or no Secret Service available, s is
+// returned instead.
+func NewKeyring(s Store, profile string) Store {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return s
+	}
+
+	k := newKeyring(conn, s)
+	k.profile = profile
+	if err := k.service().Call("org.freedesktop.DBus.Peer.Ping", 0).Err; err != nil {
+		return s
+	}
+	return k
+}
+
+func newKeyring(conn *dbus.Conn, s Store) *Keyring {
+	return &Keyring{
+		store: s,
+		conn:  conn,
+	}
+}
+
+// Read loads the document stored under name into v, taking its secrets from the keyring.
+// Secrets still found in the underlying store are returned as is, and moved to the keyring on the next write.
+func (k *Keyring) Read(name string, v interface{}) error {
+	if err := k.store.Read(name, v); err != nil {
+		return err
+	}
+
+	secrets, ok := v.(Secrets)
+	if !ok {
+		return nil
+	}
+
+	for key, field := range secrets.Secrets() {
+		if *field != "" {
+			continue
+		}
+
+		value, err := k.get(name, key)
+		if err != nil {
+			return errors.Wrapf(err, "failed reading %s of %q from keyring", key, name)
+		}
+		*field = value
+	}
+	return nil
+}
+
+// Write stores the secrets of v in the keyring, and the rest of v in the underlying store.
+// v is left unchanged.
+func (k *Keyring) Write(name string, v interface{}) error {
+	secrets, ok := v.(Secrets)
+	if !ok {
+		return k.store.Write(name, v)
+	}
+
+	fields := secrets.Secrets()
+	plain := make(map[string]string, len(fields))
+	defer func() {
+		for key, value := range plain {
+			*fields[key] = value
+		}
+	}()
+
+	for key, field := range fields {
+		if *field == "" {
+			if err := k.delete(name, key); err != nil {
+				return errors.Wrapf(err, "failed deleting %s of %q from keyring", key, name)
+			}
+			continue
+		}
+
+		if err := k.set(name, key, *field); err != nil {
+			return errors.Wrapf(err, "failed writing %s of %q to keyring", key, name)
+		}
+		plain[key] = *field
+		*field = ""
+	}
+
+	return k.store.Write(name, v)
+}
+
+// Delete removes the document stored under name, along with all its secrets in the keyring.
+func (k *Keyring) Delete(name string) error {
+	if err := k.delete(name, ""); err != nil {
+		return errors.Wrapf(err, "failed deleting secrets of %q from keyring", name)
+	}
+	return k.store.Delete(name)
+}
+
+// Lock takes the lock called name on the underlying store.
+func (k *Keyring) Lock(name string) (func(), error) {
+	return Lock(k.store, name)
+}
+
+// profileName returns the name of the profile whose secrets are kept.
+func (k *Keyring) profileName() string {
+	if k.profile == "" {
+		return profiles.Current()
+	}
+	return k.profile
+}
+
+func (k *Keyring) service() dbus.BusObject {
+	return k.conn.Object(secretsDest, secretsPath)
+}
+
+// attributes returns the lookup attributes of a secret. If key is empty, they match all
+// the secrets of the document.
+func (k *Keyring) attributes(name, key string) map[string]string {
+	attrs := map[string]string{
+		"application": keyringApp,
+		"profile":     k.profileName(),
+		"document":    name,
+	}
+
+	if key != "" {
+		attrs["secret"] = key
+	}
+	return attrs
+}
+
+// openSession opens a plain session, the secret values are protected by the bus transport.
+func (k *Keyring) openSession() (dbus.ObjectPath, error) {
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	err := k.service().Call(serviceIface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session)
+	if err != nil {
+		return "", errors.Wrap(err, "failed opening Secret Service session")
+	}
+	return session, nil
+}
+
+// search returns the items matching attrs, unlocking them if needed.
+func (k *Keyring) search(attrs map[string]string) ([]dbus.ObjectPath, error) {
+	var unlocked, locked []dbus.ObjectPath
+	if err := k.service().Call(serviceIface+".SearchItems", 0, attrs).Store(&unlocked, &locked); err != nil {
+		return nil, errors.Wrap(err, "failed searching keyring")
+	}
+
+	if len(locked) == 0 {
+		return unlocked, nil
+	}
+
+	var justUnlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	if err := k.service().Call(serviceIface+".Unlock", 0, locked).Store(&justUnlocked, &prompt); err != nil {
+		return nil, errors.Wrap(err, "failed unlocking keyring")
+	}
+
+	if err := k.prompt(prompt); err != nil {
+		return nil, err
+	}
+
+	return append(unlocked, locked...), nil
+}
+
+func (k *Keyring) get(name, key string) (string, error) {
+	items, err := k.search(k.attributes(name, key))
+	if err != nil || len(items) == 0 {
+		return "", err
+	}
+
+	session, err := k.openSession()
+	if err != nil {
+		return "", err
+	}
+	defer k.conn.Object(secretsDest, session).Call("org.freedesktop.Secret.Session.Close", 0)
+
+	var s secret
+	if err := k.conn.Object(secretsDest, items[0]).Call(itemIface+".GetSecret", 0, session).Store(&s); err != nil {
+		return "", errors.Wrap(err, "failed getting secret")
+	}
+	return string(s.Value), nil
+}
+
+func (k *Keyring) set(name, key, value string) error {
+	session, err := k.openSession()
+	if err != nil {
+		return err
+	}
+	defer k.conn.Object(secretsDest, session).Call("org.freedesktop.Secret.Session.Close", 0)
+
+	props := map[string]dbus.Variant{
+		itemIface + ".Label":      dbus.MakeVariant(fmt.Sprintf("Lift %s (%s profile)", key, k.profileName())),
+		itemIface + ".Attributes": dbus.MakeVariant(k.attributes(name, key)),
+	}
+
+	s := secret{
+		Session:     session,
+		Value:       []byte(value),
+		ContentType: "text/plain; charset=utf8",
+	}
+
+	var item, prompt dbus.ObjectPath
+	collection := k.conn.Object(secretsDest, defaultCollection)
+	if err := collection.Call(collectionIface+".CreateItem", 0, props, s, true).Store(&item, &prompt); err != nil {
+		return errors.Wrap(err, "failed creating keyring item")
+	}
+	return k.prompt(prompt)
+}
+
+func (k *Keyring) delete(name, key string) error {
+	items, err := k.search(k.attributes(name, key))
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		var prompt dbus.ObjectPath
+		if err := k.conn.Object(secretsDest, item).Call(itemIface+".Delete", 0).Store(&prompt); err != nil {
+			return errors.Wrap(err, "failed deleting keyring item")
+		}
+
+		if err := k.prompt(prompt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prompt shows the given Secret Service prompt, if any, and waits for the user to complete it.
+func (k *Keyring) prompt(path dbus.ObjectPath) error {
+	if path == "" || path == noPrompt {
+		return nil
+	}
+
+	rule := fmt.Sprintf("type='signal',interface='%s',member='Completed',path='%s'", promptIface, path)
+	if err := k.conn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, rule).Err; err != nil {
+		return errors.Wrap(err, "failed subscribing to keyring prompt")
+	}
+	defer k.conn.BusObject().Call("org.freedesktop.DBus.RemoveMatch", 0, rule)
+
+	signals := make(chan *dbus.Signal, 1)
+	k.conn.Signal(signals)
+	defer k.conn.RemoveSignal(signals)
+
+	if err := k.conn.Object(secretsDest, path).Call(promptIface+".Prompt", 0, "").Err; err != nil {
+		return errors.Wrap(err, "failed prompting to unlock keyring")
+	}
+
+	timeout := time.After(promptTimeout)
+	for {
+		select {
+		case signal := <-signals:
+			if signal.Path != path || len(signal.Body) == 0 {
+				continue
+			}
+
+			if dismissed, _ := signal.Body[0].(bool); dismissed {
+				return errors.New("keyring prompt was dismissed")
+			}
+			return nil
+		case <-timeout:
+			return errors.New("timed out waiting for keyring to be unlocked")
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// +build linux
+
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus"
+)
+
+// fakeSecrets is a minimal in-memory Secret Service, exported on a private session bus.
+type fakeSecrets struct {
+	conn  *dbus.Conn
+	mu    sync.Mutex
+	next  int
+	items map[dbus.ObjectPath]*fakeItem
+}
+
+type fakeItem struct {
+	svc   *fakeSecrets
+	path  dbus.ObjectPath
+	attrs map[string]string
+	value []byte
+}
+
+func (s *fakeSecrets) OpenSession(alg string, input dbus.Variant) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+	return dbus.MakeVariant(""), "/org/freedesktop/secrets/session/1", nil
+}
+
+func (s *fakeSecrets) SearchItems(attrs map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, *dbus.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found []dbus.ObjectPath
+	for path, item := range s.items {
+		if item.matches(attrs) {
+			found = append(found, path)
+		}
+	}
+	return found, nil, nil
+}
+
+func (s *fakeSecrets) Unlock(objects []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	return objects, noPrompt, nil
+}
+
+func (s *fakeSecrets) CreateItem(props map[string]dbus.Variant, sec secret, replace bool) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	attrs := props[itemIface+".Attributes"].Value().(map[string]string)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, item := range s.items {
+		if replace && item.matches(attrs) && len(item.attrs) == len(attrs) {
+			item.value = sec.Value
+			return item.path, noPrompt, nil
+		}
+	}
+
+	s.next++
+	item := &fakeItem{
+		svc:   s,
+		path:  dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/secrets/collection/login/%d", s.next)),
+		attrs: attrs,
+		value: sec.Value,
+	}
+	s.items[item.path] = item
+	s.conn.Export(item, item.path, itemIface)
+	return item.path, noPrompt, nil
+}
+
+func (i *fakeItem) matches(attrs map[string]string) bool {
+	for k, v := range attrs {
+		if i.attrs[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (i *fakeItem) GetSecret(session dbus.ObjectPath) (secret, *dbus.Error) {
+	return secret{Session: session, Value: i.value, ContentType: "text/plain"}, nil
+}
+
+func (i *fakeItem) Delete() (dbus.ObjectPath, *dbus.Error) {
+	i.svc.mu.Lock()
+	defer i.svc.mu.Unlock()
+
+	delete(i.svc.items, i.path)
+	return noPrompt, nil
+}
+
+// privateBus starts a dbus-daemon for the duration of the test and returns its address.
+func privateBus(t *testing.T) (string, func()) {
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+
+	cmd := exec.Command(daemon, "--session", "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		t.Skipf("failed starting dbus-daemon: %v", err)
+	}
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		cmd.Process.Kill()
+		t.Fatalf("failed reading dbus-daemon address: %+v", err)
+	}
+
+	return strings.TrimSpace(address), func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+}
+
+func connect(t *testing.T, address string) *dbus.Conn {
+	conn, err := dbus.Dial(address)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if err := conn.Auth(nil); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if err := conn.Hello(); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	return conn
+}
+
+func TestKeyring(t *testing.T) {
+	address, stop := privateBus(t)
+	defer stop()
+
+	svcConn := connect(t, address)
+	defer svcConn.Close()
+
+	svc := &fakeSecrets{conn: svcConn, items: make(map[dbus.ObjectPath]*fakeItem)}
+	svcConn.Export(svc, secretsPath, serviceIface)
+	svcConn.Export(svc, defaultCollection, collectionIface)
+	if _, err := svcConn.RequestName(secretsDest, dbus.NameFlagDoNotQueue); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	clientConn := connect(t, address)
+	defer clientConn.Close()
+
+	mem := make(memory)
+	k := newKeyring(clientConn, mem)
+
+	in := &doc{Public: "issuer", Secret: "refresh-token"}
+	if err := k.Write("doc.json", in); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if in.Secret != "refresh-token" {
+		t.Errorf("document was modified by write: %q", in.Secret)
+	}
+
+	if strings.Contains(string(mem["doc.json"]), "refresh-token") {
+		t.Errorf("secret was written to the underlying store: %s", mem["doc.json"])
+	}
+
+	out := new(doc)
+	if err := k.Read("doc.json", out); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if *out != *in {
+		t.Errorf("expected %+v, got %+v", in, out)
+	}
+
+	if err := k.Delete("doc.json"); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if len(svc.items) != 0 {
+		t.Errorf("expected keyring items to be deleted, %d left", len(svc.items))
+	}
+}
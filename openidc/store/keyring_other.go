@@ -0,0 +1,8 @@
+// +build !linux
+
+package store
+
+// NewKeyring returns s, the Secret Service API is only available on Linux.
+func NewKeyring(s Store, profile string) Store {
+	return s
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Store persists documents, such as tokens or client data, under a name within a profile.
+type Store interface {
+	// Read loads the document stored under name into v.
+	Read(name string, v interface{}) error
+	// Write stores v under name.
+	Write(name string, v interface{}) error
+	// Delete removes the document stored under name.
+	Delete(name string) error
+}
+
+// Secrets is implemented by documents holding secret values, such as refresh tokens or client
+// secrets. It allows stores to protect those values separately from non-secret data.
+type Secrets interface {
+	// Secrets returns pointers to the secret values of the document, keyed by a stable name.
+	Secrets() map[string]*string
+}
+
+// Locker is implemented by stores able to serialize updates of their documents across processes.
+type Locker interface {
+	// Lock blocks until the exclusive lock called name is taken, and returns a function releasing it.
+	Lock(name string) (func(), error)
+}
+
+// locks serializes updates within this process for stores not implementing Locker.
+var locks = struct {
+	sync.Mutex
+	m map[string]*sync.Mutex
+}{m: make(map[string]*sync.Mutex)}
+
+// Lock takes the lock called name on s, and returns a function releasing it. Stores not implementing
+// Locker are only locked within this process.
+func Lock(s Store, name string) (func(), error) {
+	if l, ok := s.(Locker); ok {
+		return l.Lock(name)
+	}
+
+	locks.Lock()
+	mu, ok := locks.m[name]
+	if !ok {
+		mu = new(sync.Mutex)
+		locks.m[name] = mu
+	}
+	locks.Unlock()
+
+	mu.Lock()
+	return mu.Unlock, nil
+}
+
+// Default is the store used to persist tokens, client data, provider configuration and signing keys
+// of the active profile. It is a plain file store unless LIFT_AUTH_STORE selects a different backend.
+var Default = New("")
+
+// New returns the store selected through LIFT_AUTH_STORE for the given profile, or for the active
+// profile if name is empty. If LIFT_AUTH_STORE has an unknown value, reading or writing from the
+// store fails, rather than silently storing secrets in plaintext.
+func New(profile string) Store {
+	f := &File{Profile: profile}
+	switch kind := os.Getenv("LIFT_AUTH_STORE"); kind {
+	case "", "file":
+		return f
+	case "encrypted":
+		return EncryptedFromEnv(f)
+	case "keyring":
+		return NewKeyring(f, profile)
+	default:
+		return &invalid{err: fmt.Errorf("unknown store %q in LIFT_AUTH_STORE, it must be file, encrypted or keyring", kind)}
+	}
+}
+
+// invalid is a store that fails all operations, returned when the store configuration is not valid.
+type invalid struct {
+	err error
+}
+
+func (s *invalid) Read(name string, v interface{}) error  { return s.err }
+func (s *invalid) Write(name string, v interface{}) error { return s.err }
+func (s *invalid) Delete(name string) error               { return s.err }
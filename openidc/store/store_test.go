@@ -0,0 +1,26 @@
+package store
+
+import (
+	"os"
+	"testing"
+)
+
+func TestNewUnknownStore(t *testing.T) {
+	prev := os.Getenv("LIFT_AUTH_STORE")
+	defer os.Setenv("LIFT_AUTH_STORE", prev)
+
+	os.Setenv("LIFT_AUTH_STORE", "plaintext")
+	s := New("work")
+	if err := s.Write("doc.json", &doc{Secret: "secret"}); err == nil {
+		t.Error("expected writes to fail with an unknown store")
+	}
+
+	if err := s.Read("doc.json", new(doc)); err == nil {
+		t.Error("expected reads to fail with an unknown store")
+	}
+
+	os.Setenv("LIFT_AUTH_STORE", "file")
+	if f, ok := New("work").(*File); !ok || f.Profile != "work" {
+		t.Errorf("expected a file store for profile work, got %#v", New("work"))
+	}
+}
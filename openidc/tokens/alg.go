@@ -0,0 +1,109 @@
+package tokens
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// IDTokenSigningAlg is the ID token signing algorithm requested when registering the Lift CLI client.
+const IDTokenSigningAlg = string(jose.ES256)
+
+// supportedAlgs are the asymmetric signature algorithms accepted for ID tokens. Symmetric algorithms
+// are left out on purpose: verifying HMAC signatures with public keys opens the door to forged tokens.
+var supportedAlgs = map[string]bool{
+	string(jose.RS256): true,
+	string(jose.RS384): true,
+	string(jose.RS512): true,
+	string(jose.PS256): true,
+	string(jose.PS384): true,
+	string(jose.PS512): true,
+	string(jose.ES256): true,
+	string(jose.ES384): true,
+	string(jose.ES512): true,
+	string(jose.EdDSA): true,
+}
+
+// SupportedAlgorithms returns the asymmetric signature algorithms we are able to verify, sorted.
+func SupportedAlgorithms() []string {
+	algs := make([]string, 0, len(supportedAlgs))
+	for alg := range supportedAlgs {
+		algs = append(algs, alg)
+	}
+	sort.Strings(algs)
+	return algs
+}
+
+// AllowedAlgorithms returns the ID token signing algorithms to accept, given the algorithms advertised by
+// the provider in id_token_signing_alg_values_supported. The algorithm we register is the only one accepted
+// if the provider supports it. Otherwise, any advertised algorithm we support is accepted.
+func AllowedAlgorithms(advertised []string) ([]string, error) {
+	if len(advertised) == 0 {
+		return []string{IDTokenSigningAlg}, nil
+	}
+
+	var allowed, unsupported []string
+	for _, alg := range advertised {
+		if alg == IDTokenSigningAlg {
+			return []string{IDTokenSigningAlg}, nil
+		}
+
+		if supportedAlgs[alg] {
+			allowed = append(allowed, alg)
+			continue
+		}
+		unsupported = append(unsupported, alg)
+	}
+
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("identity provider only advertises ID token signing algorithms we do not support: %s", strings.Join(unsupported, ", "))
+	}
+	return allowed, nil
+}
+
+// checkAlgorithm verifies that alg is allowed and consistent with the key used to verify the signature.
+func checkAlgorithm(alg string, allowed []string, jwk jose.JSONWebKey) error {
+	if alg == "" || alg == "none" {
+		return fmt.Errorf("unsigned tokens are not accepted")
+	}
+
+	found := false
+	for _, a := range allowed {
+		if a == alg {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("token signing algorithm %q is not allowed, expected one of: %s", alg, strings.Join(allowed, ", "))
+	}
+
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return fmt.Errorf("signing key %q is not meant for signatures, its use is %q", jwk.KeyID, jwk.Use)
+	}
+
+	if jwk.Algorithm != "" && jwk.Algorithm != alg {
+		return fmt.Errorf("token signing algorithm %q does not match algorithm %q of signing key %q", alg, jwk.Algorithm, jwk.KeyID)
+	}
+
+	var keyMatches bool
+	switch jwk.Key.(type) {
+	case *ecdsa.PublicKey:
+		keyMatches = strings.HasPrefix(alg, "ES")
+	case *rsa.PublicKey:
+		keyMatches = strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case ed25519.PublicKey:
+		keyMatches = alg == string(jose.EdDSA)
+	}
+
+	if !keyMatches {
+		return fmt.Errorf("token signing algorithm %q cannot be used with signing key %q of type %T", alg, jwk.KeyID, jwk.Key)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"reflect"
+	"testing"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func TestAllowedAlgorithms(t *testing.T) {
+	tests := []struct {
+		advertised []string
+		allowed    []string
+		fails      bool
+	}{
+		{nil, []string{"ES256"}, false},
+		{[]string{"RS256", "ES256"}, []string{"ES256"}, false},
+		{[]string{"RS256", "HS256"}, []string{"RS256"}, false},
+		{[]string{"HS256", "none"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		allowed, err := AllowedAlgorithms(tt.advertised)
+		if tt.fails != (err != nil) {
+			t.Errorf("%v: unexpected error result: %v", tt.advertised, err)
+		}
+
+		if !reflect.DeepEqual(allowed, tt.allowed) {
+			t.Errorf("%v: expected %v, got %v", tt.advertised, tt.allowed, allowed)
+		}
+	}
+}
+
+func TestCheckAlgorithm(t *testing.T) {
+	ecKey := newKey(t, jose.ES256)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	ec := jose.JSONWebKey{Key: &ecKey.PublicKey, KeyID: "ec", Algorithm: "ES256", Use: "sig"}
+	rs := jose.JSONWebKey{Key: &rsaKey.PublicKey, KeyID: "rs"}
+	enc := jose.JSONWebKey{Key: &ecKey.PublicKey, KeyID: "enc", Use: "enc"}
+
+	tests := []struct {
+		desc    string
+		alg     string
+		allowed []string
+		jwk     jose.JSONWebKey
+		fails   bool
+	}{
+		{"matching key", "ES256", []string{"ES256"}, ec, false},
+		{"none", "none", []string{"ES256"}, ec, true},
+		{"not allowed", "ES384", []string{"ES256"}, ec, true},
+		{"key algorithm mismatch", "ES384", []string{"ES384"}, ec, true},
+		{"key type mismatch", "ES256", []string{"ES256"}, rs, true},
+		{"HMAC with public key", "HS256", []string{"HS256"}, rs, true},
+		{"encryption key", "ES256", []string{"ES256"}, enc, true},
+		{"rsa key", "PS256", []string{"PS256"}, rs, false},
+	}
+
+	for _, tt := range tests {
+		err := checkAlgorithm(tt.alg, tt.allowed, tt.jwk)
+		if tt.fails != (err != nil) {
+			t.Errorf("%s: unexpected error result: %v", tt.desc, err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package tokens
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/lift-plugins/auth/openidc/store"
+	"github.com/pkg/errors"
+)
+
+// ExchangeCode exchanges an authorization code for tokens, sending the PKCE code verifier
+// that matches the challenge sent in the authorization request.
+// https://tools.ietf.org/html/rfc6749#section-4.1.3
+// https://tools.ietf.org/html/rfc7636#section-4.5
+//
+// Returned tokens do not have an issuer set, it is up to the caller to set it.
+func ExchangeCode(ctx context.Context, tokenEndpoint, clientID, clientSecret, code, redirectURI, verifier string) (*Tokens, error) {
+	formValues := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {clientID},
+		"code_verifier": {verifier},
+	}
+
+	tokenRes, err := requestTokens(ctx, tokenEndpoint, clientID, clientSecret, formValues)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed exchanging authorization code")
+	}
+
+	return &Tokens{
+		ID:      tokenRes.IDToken,
+		Access:  tokenRes.AccessToken,
+		Refresh: tokenRes.RefreshToken,
+	}, nil
+}
+
+// VerifyCode validates the c_hash claim in the ID token against the authorization code
+// it was issued with, if present. Signing keys are read from s.
+// http://openid.net/specs/openid-connect-core-1_0.html#HybridIDToken
+func (tks *Tokens) VerifyCode(ctx context.Context, s store.Store, code string) error {
+	header, err := Verify(ctx, s, tks.ID)
+	if err != nil {
+		return err
+	}
+
+	idToken, err := Decode(tks.ID)
+	if err != nil {
+		return err
+	}
+
+	if idToken.CHash == "" {
+		return nil
+	}
+
+	if hash(code, header.Algorithm) != idToken.CHash {
+		return invalidClaim("c_hash", "calculated hash value from authorization code doesn't match value declared in ID token")
+	}
+	return nil
+}
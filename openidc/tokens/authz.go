@@ -0,0 +1,65 @@
+package tokens
+
+import (
+	"strings"
+)
+
+// scopeSeparator separates the levels of hierarchical scopes, such as apps:deploy.
+const scopeSeparator = ":"
+
+// HasScope returns whether the token was granted scope. Scopes are hierarchical, a granted scope
+// also grants the scopes below it: apps grants apps:deploy. A * level matches any single level,
+// or all the remaining ones if it is the last: apps:* grants apps:deploy and apps:deploy:prod,
+// and * grants every scope.
+func (t *JSONWebToken) HasScope(scope string) bool {
+	for _, granted := range t.Scope {
+		if scopeCovers(granted, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScopes returns whether the token was granted all the given scopes.
+func (t *JSONWebToken) HasScopes(scopes ...string) bool {
+	for _, scope := range scopes {
+		if !t.HasScope(scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// IntendedFor returns whether aud is one of the token audiences. Trailing slashes are ignored, so that
+// https://api.hooklift.io and https://api.hooklift.io/ match.
+func (t *JSONWebToken) IntendedFor(aud string) bool {
+	aud = strings.TrimSuffix(aud, "/")
+	for _, v := range t.Audience {
+		if strings.TrimSuffix(v, "/") == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeCovers returns whether the granted scope grants the required one.
+func scopeCovers(granted, required string) bool {
+	if granted == required {
+		return true
+	}
+
+	g := strings.Split(granted, scopeSeparator)
+	r := strings.Split(required, scopeSeparator)
+	for i, level := range g {
+		if level == "*" && i == len(g)-1 {
+			return i < len(r)
+		}
+
+		if i >= len(r) || (level != "*" && level != r[i]) {
+			return false
+		}
+	}
+
+	// Granted scope is an ancestor of the required one.
+	return true
+}
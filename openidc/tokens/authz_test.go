@@ -0,0 +1,70 @@
+package tokens
+
+import "testing"
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		granted  string
+		required string
+		expected bool
+	}{
+		{"apps:deploy", "apps:deploy", true},
+		{"apps:deploy", "apps:delete", false},
+		{"apps", "apps:deploy", true},
+		{"apps:deploy", "apps", false},
+		{"apps:*", "apps:deploy", true},
+		{"apps:*", "apps:deploy:prod", true},
+		{"apps:*", "apps", false},
+		{"apps:*:read", "apps:web:read", true},
+		{"apps:*:read", "apps:web:write", false},
+		{"*", "admin", true},
+		{"admin", "administrator", false},
+	}
+
+	for _, tt := range tests {
+		token := &JSONWebToken{Scope: []string{tt.granted}}
+		if actual := token.HasScope(tt.required); actual != tt.expected {
+			t.Errorf("granted %q, required %q: expected %t, got %t", tt.granted, tt.required, tt.expected, actual)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	token := &JSONWebToken{
+		Subject:       "user",
+		Audience:      Audience{"https://api.hooklift.io/"},
+		Scope:         []string{"apps:*", "email"},
+		EmailVerified: true,
+	}
+
+	tests := []struct {
+		expr     string
+		expected bool
+	}{
+		{"scope apps:deploy and email_verified", true},
+		{"scope admin", false},
+		{"scope admin or scope apps:deploy", true},
+		{"not scope admin", true},
+		{"scope admin or scope email and not email_verified", false},
+		{"(scope admin or scope email) and email_verified", true},
+		{"aud https://api.hooklift.io and sub user", true},
+	}
+
+	for _, tt := range tests {
+		policy, err := ParsePolicy(tt.expr)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.expr, err)
+			continue
+		}
+
+		if actual := policy.Allows(token); actual != tt.expected {
+			t.Errorf("%q: expected %t, got %t", tt.expr, tt.expected, actual)
+		}
+	}
+
+	for _, expr := range []string{"", "scope", "scope admin and", "(scope admin", "admin", "scope admin )"} {
+		if _, err := ParsePolicy(expr); err == nil {
+			t.Errorf("%q: expected error", expr)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package tokens
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lift-plugins/auth/openidc/discovery"
+	"github.com/lift-plugins/auth/openidc/store"
+	"github.com/pkg/errors"
+)
+
+// ClientCredentials requests tokens for a service identity using the OAuth 2.0 Client Credentials
+// Grant. https://tools.ietf.org/html/rfc6749#section-4.4
+//
+// Returned tokens are marked as belonging to a service identity and do not have an issuer set,
+// it is up to the caller to set it.
+func ClientCredentials(ctx context.Context, tokenEndpoint, clientID, clientSecret string, scope, audience []string) (*Tokens, error) {
+	formValues := url.Values{
+		"grant_type": {"client_credentials"},
+	}
+
+	if len(scope) > 0 {
+		formValues.Set("scope", strings.Join(scope, " "))
+	}
+
+	if len(audience) > 0 {
+		formValues["audience"] = audience
+	}
+
+	tokenRes, err := requestTokens(ctx, tokenEndpoint, clientID, clientSecret, formValues)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed requesting client credentials grant")
+	}
+
+	tks := &Tokens{
+		Access:          tokenRes.AccessToken,
+		ServiceIdentity: true,
+		Scope:           scope,
+		Audience:        audience,
+	}
+
+	if tokenRes.ExpiresIn > 0 {
+		tks.ExpiresAt = time.Now().Add(time.Duration(tokenRes.ExpiresIn) * time.Second).Unix()
+	}
+
+	return tks, nil
+}
+
+// serviceExpired returns whether the access token of a service identity has expired. Access
+// tokens are not required to be JWTs, so the expiration reported by the token endpoint is
+// preferred.
+func (tks *Tokens) serviceExpired() bool {
+	if tks.ExpiresAt != 0 {
+		return time.Now().After(time.Unix(tks.ExpiresAt, 0).Add(-leeway))
+	}
+
+	accessToken, err := Decode(tks.Access)
+	if err != nil {
+		return false
+	}
+	return accessToken.Expired()
+}
+
+// refreshServiceIdentity re-runs the client credentials grant, since service identities are
+// not issued refresh tokens. The scope and audience requested at sign-in are requested again,
+// access tokens may be opaque. New tokens are written to s.
+func (tks *Tokens) refreshServiceIdentity(ctx context.Context, s store.Store, clientID, clientSecret string) error {
+	config := new(discovery.ProviderConfig)
+	if err := config.Read(s); err != nil {
+		return err
+	}
+
+	newTokens, err := ClientCredentials(ctx, config.TokenEndpoint, clientID, clientSecret, tks.Scope, tks.Audience)
+	if err != nil {
+		return err
+	}
+	newTokens.Issuer = tks.Issuer
+
+	if err := newTokens.Write(s); err != nil {
+		return err
+	}
+
+	*tks = *newTokens
+	return nil
+}
@@ -0,0 +1,52 @@
+package tokens
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRefreshServiceIdentityRequestsSignInScope(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if scope := r.PostFormValue("scope"); scope != "read write" {
+			t.Errorf("expected the scope requested at sign-in, got %q", scope)
+		}
+
+		if audience := r.PostForm["audience"]; !reflect.DeepEqual(audience, []string{"api"}) {
+			t.Errorf("expected the audience requested at sign-in, got %q", audience)
+		}
+		fmt.Fprint(w, `{"access_token": "renewed", "token_type": "Bearer", "expires_in": 3600}`)
+	}))
+	defer srv.Close()
+
+	s := newTestProvider(t, srv.URL).store
+
+	// Access tokens are opaque, so scope and audience can only come from the stored tokens.
+	tks := &Tokens{
+		Access:          "opaque",
+		ServiceIdentity: true,
+		ExpiresAt:       time.Now().Add(-time.Hour).Unix(),
+		Scope:           []string{"read", "write"},
+		Audience:        []string{"api"},
+	}
+	if err := tks.Write(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tks.RefreshToken(context.Background(), s, "ci", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := new(Tokens)
+	if err := stored.Read(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.Access != "renewed" || !reflect.DeepEqual(stored.Scope, tks.Scope) || !reflect.DeepEqual(stored.Audience, tks.Audience) {
+		t.Errorf("expected renewed tokens to keep scope and audience, got %+v", stored)
+	}
+}
@@ -0,0 +1,171 @@
+package tokens
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hooklift/lift/ui"
+	"github.com/lift-plugins/auth/openidc/oauth2"
+	"github.com/pkg/errors"
+)
+
+// deviceCodeGrant is the grant type used to poll the token endpoint during the
+// Device Authorization Grant flow. https://tools.ietf.org/html/rfc8628#section-3.4
+const deviceCodeGrant = "urn:ietf:params:oauth:grant-type:device_code"
+
+const (
+	// defaultPollInterval is used when the provider does not specify a polling interval.
+	defaultPollInterval = 5 * time.Second
+
+	// maxPollFailures is how many transient failures in a row are tolerated while polling.
+	maxPollFailures = 3
+)
+
+// DeviceCode holds the device authorization response from the OpenIDC Provider.
+// https://tools.ietf.org/html/rfc8628#section-3.2
+type DeviceCode struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+	Error                   string `json:"error"`
+	ErrorDescription        string `json:"error_description"`
+	ErrorURI                string `json:"error_uri"`
+}
+
+// RequestDeviceCode starts the Device Authorization Grant flow by requesting a device and user code
+// from the provider's device authorization endpoint.
+func RequestDeviceCode(ctx context.Context, endpoint, clientID, clientSecret string, scope, audience []string) (*DeviceCode, error) {
+	if endpoint == "" {
+		return nil, errors.New("identity provider does not support the device authorization grant")
+	}
+
+	formValues := url.Values{
+		"client_id": {clientID},
+		"scope":     {strings.Join(scope, " ")},
+		"audience":  audience,
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(formValues.Encode()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed preparing HTTP request")
+	}
+
+	req.SetBasicAuth(clientID, clientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := oauth2.Do(ctx, req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed requesting device code")
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20)) // reads up to 1mb
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed reading response body")
+	}
+
+	code := new(DeviceCode)
+	if err := json.Unmarshal(body, code); err != nil {
+		return nil, errors.Wrapf(err, "failed unmarshaling response: %s", string(body[:]))
+	}
+
+	if code.Error != "" {
+		return nil, &ProviderError{
+			Code:        code.Error,
+			Description: code.ErrorDescription,
+			URI:         code.ErrorURI,
+		}
+	}
+
+	if code.DeviceCode == "" || code.UserCode == "" || code.VerificationURI == "" {
+		return nil, fmt.Errorf("incomplete device authorization response: %s", string(body[:]))
+	}
+
+	return code, nil
+}
+
+// Poll polls the token endpoint until the user approves or denies the device authorization request,
+// or until the device code expires. It honors the polling interval requested by the provider
+// and slows down when asked to. https://tools.ietf.org/html/rfc8628#section-3.5
+//
+// Transient failures, such as network errors or server errors, are retried up to maxPollFailures
+// times in a row. If ctx is canceled, its error is returned.
+//
+// Returned tokens do not have an issuer set, it is up to the caller to set it.
+func (d *DeviceCode) Poll(ctx context.Context, tokenEndpoint, clientID, clientSecret string) (*Tokens, error) {
+	interval := time.Duration(d.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	pollCtx := ctx
+	if d.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		pollCtx, cancel = context.WithTimeout(ctx, time.Duration(d.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+
+	formValues := url.Values{
+		"grant_type":  {deviceCodeGrant},
+		"device_code": {d.DeviceCode},
+		"client_id":   {clientID},
+	}
+
+	failures := 0
+	for {
+		select {
+		case <-pollCtx.Done():
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			return nil, errors.New("device code expired before authorization was completed")
+		case <-time.After(interval):
+		}
+
+		tokenRes, err := requestTokens(pollCtx, tokenEndpoint, clientID, clientSecret, formValues)
+		if err == nil {
+			return &Tokens{
+				ID:      tokenRes.IDToken,
+				Access:  tokenRes.AccessToken,
+				Refresh: tokenRes.RefreshToken,
+			}, nil
+		}
+
+		perr, ok := errors.Cause(err).(*ProviderError)
+		if !ok {
+			if pollCtx.Err() != nil {
+				continue
+			}
+
+			failures++
+			if failures > maxPollFailures {
+				return nil, err
+			}
+			ui.Debug("%+v", errors.Wrap(err, "failed polling token endpoint, retrying"))
+			continue
+		}
+		failures = 0
+
+		switch perr.Code {
+		case "authorization_pending":
+		case "slow_down":
+			interval += defaultPollInterval
+		case "access_denied":
+			return nil, errors.New("device authorization request was denied")
+		case "expired_token":
+			return nil, errors.New("device code expired before authorization was completed")
+		default:
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package tokens
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPollRetriesTransientErrors(t *testing.T) {
+	var polls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		switch polls {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{}`)
+		case 2:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "authorization_pending"}`)
+		default:
+			fmt.Fprint(w, `{"access_token": "access", "id_token": "id", "refresh_token": "refresh"}`)
+		}
+	}))
+	defer srv.Close()
+
+	code := &DeviceCode{DeviceCode: "device", Interval: 1, ExpiresIn: 30}
+	tks, err := code.Poll(context.Background(), srv.URL, "client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tks.Access != "access" || polls != 3 {
+		t.Errorf("expected tokens after 3 polls, got %+v after %d", tks, polls)
+	}
+}
+
+func TestPollGivesUpAfterFailures(t *testing.T) {
+	var polls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	code := &DeviceCode{DeviceCode: "device", Interval: 1, ExpiresIn: 30}
+	if _, err := code.Poll(context.Background(), srv.URL, "client", "secret"); err == nil {
+		t.Fatal("expected error once failures keep happening")
+	}
+
+	if polls != maxPollFailures+1 {
+		t.Errorf("expected %d polls, got %d", maxPollFailures+1, polls)
+	}
+}
+
+func TestPollCanceled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": "authorization_pending"}`)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	code := &DeviceCode{DeviceCode: "device", Interval: 1, ExpiresIn: 30}
+	if _, err := code.Poll(ctx, srv.URL, "client", "secret"); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+package tokens
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hooklift/lift/ui"
+	"github.com/lift-plugins/auth/openidc/discovery"
+	"github.com/lift-plugins/auth/openidc/store"
+	"github.com/pkg/errors"
+)
+
+const (
+	// exchangedFile is the name under which exchanged tokens are stored.
+	exchangedFile = "exchanged.json"
+	// exchangedLockFile is the name of the file locked while updating exchanged tokens.
+	exchangedLockFile = "exchanged.lock"
+
+	tokenExchangeGrant = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenType    = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// ExchangedToken is an access token obtained through token exchange, narrowed down to a
+// single audience and a subset of the session scopes.
+type ExchangedToken struct {
+	Access   string   `json:"access"`
+	Refresh  string   `json:"refresh,omitempty"`
+	Audience string   `json:"audience"`
+	Scope    []string `json:"scope,omitempty"`
+	// ExpiresAt is the access token expiration, in seconds since epoch. Zero if unknown.
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// Expired returns whether the access token expired.
+func (t *ExchangedToken) Expired() bool {
+	if t.ExpiresAt != 0 {
+		return time.Now().After(time.Unix(t.ExpiresAt, 0).Add(-leeway))
+	}
+
+	accessToken, err := Decode(t.Access)
+	if err != nil {
+		// Opaque tokens without a known expiration are not cached.
+		return true
+	}
+	return accessToken.Expired()
+}
+
+// exchangedTokens holds exchanged tokens, keyed by audience and scope.
+type exchangedTokens struct {
+	Tokens map[string]*ExchangedToken `json:"tokens"`
+}
+
+// Secrets returns the access and refresh tokens of exchanged tokens, so that stores can protect them.
+func (e *exchangedTokens) Secrets() map[string]*string {
+	secrets := make(map[string]*string, 2*len(e.Tokens))
+	for key, t := range e.Tokens {
+		secrets["access "+key] = &t.Access
+		secrets["refresh "+key] = &t.Refresh
+	}
+	return secrets
+}
+
+// ReadExchanged returns the exchanged tokens cached in s, sorted by audience and scope.
+func ReadExchanged(s store.Store) ([]*ExchangedToken, error) {
+	cache := new(exchangedTokens)
+	if err := s.Read(exchangedFile, cache); err != nil {
+		return nil, errors.Wrap(err, "failed reading exchanged tokens")
+	}
+
+	keys := make([]string, 0, len(cache.Tokens))
+	for key := range cache.Tokens {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	exchanged := make([]*ExchangedToken, 0, len(keys))
+	for _, key := range keys {
+		exchanged = append(exchanged, cache.Tokens[key])
+	}
+	return exchanged, nil
+}
+
+// Exchange returns an access token for a single audience and a subset of the session scopes, using
+// OAuth 2.0 Token Exchange as specified in https://tools.ietf.org/html/rfc8693. Exchanged tokens are
+// cached per audience and scope until they expire. Expired ones are refreshed with their own refresh
+// token, if the provider issued one, or exchanged again otherwise. Exchanged tokens are cached in s.
+func (tks *Tokens) Exchange(ctx context.Context, s store.Store, clientID, clientSecret, audience string, scope []string) (*ExchangedToken, error) {
+	if audience == "" {
+		return nil, errors.New("an audience is required to exchange tokens")
+	}
+
+	scope = append([]string(nil), scope...)
+	sort.Strings(scope)
+	key := audience + " " + strings.Join(scope, " ")
+
+	release, err := store.Lock(s, exchangedLockFile)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Missing exchanged tokens only means none were cached yet.
+	cache := new(exchangedTokens)
+	s.Read(exchangedFile, cache)
+	if cache.Tokens == nil {
+		cache.Tokens = make(map[string]*ExchangedToken)
+	}
+
+	cached, ok := cache.Tokens[key]
+	if ok && !cached.Expired() {
+		return cached, nil
+	}
+
+	config := new(discovery.ProviderConfig)
+	if err := config.Read(s); err != nil {
+		return nil, err
+	}
+
+	var exchanged *ExchangedToken
+	if ok && cached.Refresh != "" {
+		exchanged, err = refreshExchanged(ctx, config.TokenEndpoint, clientID, clientSecret, cached)
+		if err != nil {
+			// The refresh token may have expired or been revoked, so we exchange the session tokens again.
+			ui.Debug("%+v", err)
+			exchanged = nil
+		}
+	}
+
+	if exchanged == nil {
+		if err := tks.RefreshToken(ctx, s, clientID, clientSecret); err != nil {
+			return nil, err
+		}
+
+		exchanged, err = exchange(ctx, config.TokenEndpoint, clientID, clientSecret, tks.Access, audience, scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cache.Tokens[key] = exchanged
+	if err := s.Write(exchangedFile, cache); err != nil {
+		return nil, errors.Wrap(err, "failed writing exchanged tokens")
+	}
+
+	return exchanged, nil
+}
+
+// exchange trades the subject access token for one restricted to audience and scope.
+func exchange(ctx context.Context, tokenEndpoint, clientID, clientSecret, subjectToken, audience string, scope []string) (*ExchangedToken, error) {
+	formValues := url.Values{
+		"grant_type":           {tokenExchangeGrant},
+		"subject_token":        {subjectToken},
+		"subject_token_type":   {accessTokenType},
+		"requested_token_type": {accessTokenType},
+		"audience":             {audience},
+	}
+
+	if len(scope) > 0 {
+		formValues.Set("scope", strings.Join(scope, " "))
+	}
+
+	tokenRes, err := requestTokens(ctx, tokenEndpoint, clientID, clientSecret, formValues)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed exchanging tokens for audience %q", audience)
+	}
+
+	if tokenRes.IssuedTokenType != "" && tokenRes.IssuedTokenType != accessTokenType {
+		return nil, errors.Errorf("provider issued a token of type %q instead of an access token", tokenRes.IssuedTokenType)
+	}
+
+	return newExchangedToken(tokenRes, audience, scope), nil
+}
+
+// refreshExchanged gets a new access token using the refresh token issued along with an exchanged token.
+func refreshExchanged(ctx context.Context, tokenEndpoint, clientID, clientSecret string, t *ExchangedToken) (*ExchangedToken, error) {
+	formValues := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {t.Refresh},
+	}
+
+	if len(t.Scope) > 0 {
+		formValues.Set("scope", strings.Join(t.Scope, " "))
+	}
+
+	tokenRes, err := requestTokens(ctx, tokenEndpoint, clientID, clientSecret, formValues)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed refreshing exchanged token for audience %q", t.Audience)
+	}
+
+	refreshed := newExchangedToken(tokenRes, t.Audience, t.Scope)
+	if refreshed.Refresh == "" {
+		refreshed.Refresh = t.Refresh
+	}
+	return refreshed, nil
+}
+
+// newExchangedToken builds an exchanged token out of a token endpoint response. Scopes granted
+// by the provider take precedence over the requested ones.
+func newExchangedToken(tokenRes *tokenResponse, audience string, scope []string) *ExchangedToken {
+	t := &ExchangedToken{
+		Access:   tokenRes.AccessToken,
+		Refresh:  tokenRes.RefreshToken,
+		Audience: audience,
+		Scope:    scope,
+	}
+
+	if tokenRes.Scope != "" {
+		t.Scope = strings.Fields(tokenRes.Scope)
+	}
+
+	if tokenRes.ExpiresIn > 0 {
+		t.ExpiresAt = time.Now().Add(time.Duration(tokenRes.ExpiresIn) * time.Second).Unix()
+	}
+
+	return t
+}
@@ -0,0 +1,136 @@
+package tokens
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// exchangeSession stores a session whose tokens are exchanged at tokenEndpoint.
+func exchangeSession(t *testing.T, tokenEndpoint string) (memStore, *Tokens) {
+	p := newTestProvider(t, tokenEndpoint)
+	tks := &Tokens{Access: "session", ServiceIdentity: true, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	if err := tks.Write(p.store); err != nil {
+		t.Fatal(err)
+	}
+	return p.store, tks
+}
+
+func TestExchangeCachesPerAudienceAndScope(t *testing.T) {
+	var exchanges int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if grant := r.PostFormValue("grant_type"); grant != tokenExchangeGrant {
+			t.Errorf("unexpected grant %q", grant)
+		}
+
+		if subject := r.PostFormValue("subject_token"); subject != "session" {
+			t.Errorf("expected the session access token to be exchanged, got %q", subject)
+		}
+
+		exchanges++
+		fmt.Fprintf(w, `{"access_token": "exchanged-%d", "token_type": "Bearer", "expires_in": 3600}`, exchanges)
+	}))
+	defer srv.Close()
+
+	s, tks := exchangeSession(t, srv.URL)
+	ctx := context.Background()
+	exchange := func(audience string, scope ...string) string {
+		exchanged, err := tks.Exchange(ctx, s, "client", "secret", audience, scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return exchanged.Access
+	}
+
+	first := exchange("api", "write", "read")
+	if cached := exchange("api", "read", "write"); cached != first {
+		t.Errorf("expected token cached for the same audience and scope, got %q and %q", first, cached)
+	}
+
+	if other := exchange("api", "read"); other == first {
+		t.Error("expected a different token for a different scope")
+	}
+
+	if other := exchange("billing", "read", "write"); other == first {
+		t.Error("expected a different token for a different audience")
+	}
+
+	if exchanges != 3 {
+		t.Errorf("expected 3 exchanges, got %d", exchanges)
+	}
+
+	exchanged, err := ReadExchanged(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(exchanged) != 3 || exchanged[0].Audience != "api" || exchanged[2].Audience != "billing" {
+		t.Errorf("unexpected cached tokens: %+v", exchanged)
+	}
+}
+
+func TestExchangeRefresh(t *testing.T) {
+	tests := []struct {
+		desc         string
+		refreshFails bool
+		access       string
+		refresh      string
+	}{
+		{"refreshed", false, "refreshed", "stale"},
+		{"exchanged again", true, "exchanged", "new"},
+	}
+
+	for _, tt := range tests {
+		var refreshes, exchanges int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.PostFormValue("grant_type") {
+			case "refresh_token":
+				refreshes++
+				if r.PostFormValue("refresh_token") != "stale" {
+					t.Errorf("%s: expected the cached refresh token, got %q", tt.desc, r.PostFormValue("refresh_token"))
+				}
+
+				if tt.refreshFails {
+					w.WriteHeader(http.StatusBadRequest)
+					fmt.Fprint(w, `{"error": "invalid_grant"}`)
+					return
+				}
+				fmt.Fprint(w, `{"access_token": "refreshed", "token_type": "Bearer", "expires_in": 3600}`)
+			case tokenExchangeGrant:
+				exchanges++
+				fmt.Fprint(w, `{"access_token": "exchanged", "refresh_token": "new", "token_type": "Bearer", "expires_in": 3600}`)
+			}
+		}))
+
+		s, tks := exchangeSession(t, srv.URL)
+		cache := &exchangedTokens{Tokens: map[string]*ExchangedToken{
+			"api read": {Access: "expired", Refresh: "stale", Audience: "api", Scope: []string{"read"}, ExpiresAt: time.Now().Add(-time.Hour).Unix()},
+		}}
+		if err := s.Write(exchangedFile, cache); err != nil {
+			t.Fatal(err)
+		}
+
+		exchanged, err := tks.Exchange(context.Background(), s, "client", "secret", "api", []string{"read"})
+		srv.Close()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+
+		if exchanged.Access != tt.access || exchanged.Refresh != tt.refresh {
+			t.Errorf("%s: unexpected token %+v", tt.desc, exchanged)
+		}
+
+		wantExchanges := 0
+		if tt.refreshFails {
+			wantExchanges = 1
+		}
+
+		if refreshes != 1 || exchanges != wantExchanges {
+			t.Errorf("%s: expected 1 refresh and %d exchanges, got %d and %d", tt.desc, wantExchanges, refreshes, exchanges)
+		}
+	}
+}
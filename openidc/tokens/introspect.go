@@ -0,0 +1,56 @@
+package tokens
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Introspection holds the provider's view of a token, as returned by its introspection endpoint.
+// https://tools.ietf.org/html/rfc7662#section-2.2
+type Introspection struct {
+	// Active tells whether the token is currently active. When false, other fields are usually empty.
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Expires   int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// Scopes returns the token scopes.
+func (i *Introspection) Scopes() []string {
+	return strings.Fields(i.Scope)
+}
+
+// Introspect asks the provider's introspection endpoint whether a token is still active, as specified
+// in https://tools.ietf.org/html/rfc7662. hint is the type of token, either "refresh_token" or
+// "access_token".
+func Introspect(ctx context.Context, endpoint, clientID, clientSecret, token, hint string) (*Introspection, error) {
+	if endpoint == "" {
+		return nil, errors.New("identity provider does not support token introspection")
+	}
+
+	resp, err := postToken(ctx, "introspecting", endpoint, clientID, clientSecret, token, hint)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	introspection := new(Introspection)
+	decoder := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)) // limits reading to 1mb
+	if err := decoder.Decode(introspection); err != nil {
+		return nil, errors.Wrapf(err, "failed decoding introspection response")
+	}
+
+	return introspection, nil
+}
@@ -0,0 +1,64 @@
+package tokens
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestIntrospect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "client" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "invalid_client"}`)
+			return
+		}
+
+		if hint := r.PostFormValue("token_type_hint"); hint != "access_token" {
+			t.Errorf("unexpected token type hint %q", hint)
+		}
+
+		switch r.PostFormValue("token") {
+		case "active":
+			fmt.Fprint(w, `{"active": true, "scope": "openid profile", "client_id": "lift-cli", "sub": "user", "aud": ["api"]}`)
+		default:
+			fmt.Fprint(w, `{"active": false}`)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	introspection, err := Introspect(ctx, srv.URL, "client", "secret", "active", "access_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !introspection.Active || introspection.Subject != "user" || !introspection.Audience.Contains("api") {
+		t.Errorf("unexpected introspection: %+v", introspection)
+	}
+
+	if scopes := introspection.Scopes(); !reflect.DeepEqual(scopes, []string{"openid", "profile"}) {
+		t.Errorf("unexpected scopes: %v", scopes)
+	}
+
+	introspection, err = Introspect(ctx, srv.URL, "client", "secret", "revoked", "access_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if introspection.Active {
+		t.Errorf("expected inactive token, got %+v", introspection)
+	}
+
+	_, err = Introspect(ctx, srv.URL, "client", "wrong", "active", "access_token")
+	if perr, ok := err.(*ProviderError); !ok || perr.Code != "invalid_client" {
+		t.Errorf("expected provider error, got %#v", err)
+	}
+
+	if _, err := Introspect(ctx, "", "client", "secret", "active", "access_token"); err == nil {
+		t.Error("expected an error without an introspection endpoint")
+	}
+}
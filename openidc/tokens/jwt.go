@@ -1,6 +1,7 @@
 package tokens
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	jose "gopkg.in/square/go-jose.v2"
 
 	"github.com/lift-plugins/auth/openidc/discovery"
+	"github.com/lift-plugins/auth/openidc/store"
 	"github.com/pkg/errors"
 )
 
@@ -25,7 +27,7 @@ type JSONWebToken struct {
 	// Subject identifies the principal that is the subject of the token.
 	Subject string `json:"sub,omitempty"`
 	// Audiencie identifies the recipients that the token is intended for.
-	Audience []string `json:"aud,omitempty"`
+	Audience Audience `json:"aud,omitempty"`
 	// Expires is the expiration time on or after which the JWT MUST NOT be accepted for processing.
 	Expires int64 `json:"exp,omitempty"`
 	// NotBefore identifies the time before which the JWT MUST NOT be accepted for processing
@@ -50,6 +52,36 @@ type JSONWebToken struct {
 	Scope []string `json:"scope,omitempty"`
 }
 
+// Audience holds the aud claim, which can be either a single string or an array of strings.
+// https://tools.ietf.org/html/rfc7519#section-4.1.3
+type Audience []string
+
+// UnmarshalJSON decodes the aud claim from its string or array form.
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return errors.Wrap(err, "aud claim must be a string or an array of strings")
+	}
+	*a = Audience(multiple)
+	return nil
+}
+
+// Contains returns whether aud is one of the audiences.
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
 // Expired returns whether or not the token has expired.
 func (t *JSONWebToken) Expired() bool {
 	expiry := time.Unix(t.Expires, 0)
@@ -60,8 +92,33 @@ func (t *JSONWebToken) Expired() bool {
 	return time.Now().After(expiry.Add(-leeway))
 }
 
-// Verify checks token signature and returns its payload and signature header.
-func Verify(token string) (jose.Header, error) {
+// Verify checks token signature and returns its payload and signature header. Only signatures made
+// with an allowed algorithm, consistent with the signing key, are accepted. Provider configuration and
+// signing keys are read from s, unknown keys are fetched from the provider.
+func Verify(ctx context.Context, s store.Store, token string) (jose.Header, error) {
+	config := new(discovery.ProviderConfig)
+	if err := config.Read(s); err != nil {
+		return jose.Header{}, err
+	}
+
+	allowed, err := AllowedAlgorithms(config.IDTokenSigAlgs)
+	if err != nil {
+		return jose.Header{}, err
+	}
+
+	keys := new(discovery.SigningKeys)
+	if err := keys.Read(s); err != nil {
+		return jose.Header{}, err
+	}
+
+	return VerifyWith(token, allowed, func(kid string) (jose.JSONWebKey, error) {
+		return keys.Lookup(ctx, s, kid, config.JWKSURI)
+	})
+}
+
+// VerifyWith checks token signature using the key returned by lookup for the token key ID, instead
+// of the stored signing keys. It allows verifying tokens without a local session, such as in servers.
+func VerifyWith(token string, allowed []string, lookup func(kid string) (jose.JSONWebKey, error)) (jose.Header, error) {
 	var header jose.Header
 	jws, err := jose.ParseSigned(token)
 	if err != nil {
@@ -72,14 +129,13 @@ func Verify(token string) (jose.Header, error) {
 		return header, errors.New("too many or too few signatures")
 	}
 
-	keys := new(discovery.SigningKeys)
-	if err := keys.Read(); err != nil {
+	header = jws.Signatures[0].Header
+	jwk, err := lookup(header.KeyID)
+	if err != nil {
 		return header, err
 	}
 
-	header = jws.Signatures[0].Header
-	jwk, err := keys.Key(header.KeyID)
-	if err != nil {
+	if err := checkAlgorithm(header.Algorithm, allowed, jwk); err != nil {
 		return header, err
 	}
 
@@ -93,20 +149,29 @@ func Verify(token string) (jose.Header, error) {
 
 // Decode decodes a base64 encoded JWT token, without verifying its signature.
 func Decode(token string) (*JSONWebToken, error) {
+	jwt := new(JSONWebToken)
+	if err := decodePayload(token, jwt); err != nil {
+		return nil, err
+	}
+
+	return jwt, nil
+}
+
+// decodePayload decodes the payload of a base64 encoded JWT token into v, without verifying its signature.
+func decodePayload(token string, v interface{}) error {
 	parts := strings.Split(token, ".")
 	if len(parts) != 3 {
-		return nil, fmt.Errorf("compact JWS format must have three parts")
+		return fmt.Errorf("compact JWS format must have three parts")
 	}
 
 	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
 	if err != nil {
-		return nil, errors.Wrap(err, "failed decoding token payload")
+		return errors.Wrap(err, "failed decoding token payload")
 	}
 
-	jwt := new(JSONWebToken)
-	if err := json.Unmarshal(payload, jwt); err != nil {
-		return nil, errors.Wrap(err, "failed decoding token")
+	if err := json.Unmarshal(payload, v); err != nil {
+		return errors.Wrap(err, "failed decoding token")
 	}
 
-	return jwt, nil
+	return nil
 }
@@ -0,0 +1,176 @@
+package tokens
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Policy is an authorization rule evaluated against token claims, such as
+// "scope apps:deploy and email_verified". Expressions are made of the following conditions,
+// combined with and, or, not and parentheses:
+//
+//	scope <scope>     The token was granted scope, as in HasScope.
+//	aud <audience>    The token is intended for audience, as in IntendedFor.
+//	iss <issuer>      The token was issued by issuer.
+//	sub <subject>     The token subject is subject.
+//	email <email>     The token email claim is email.
+//	email_verified    The token email was verified.
+//
+// and takes precedence over or.
+type Policy struct {
+	expr string
+	root policyNode
+}
+
+// policyNode is a node of a parsed policy expression.
+type policyNode func(t *JSONWebToken) bool
+
+// ParsePolicy parses a policy expression.
+func ParsePolicy(expr string) (*Policy, error) {
+	p := &policyParser{tokens: tokenizePolicy(expr)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty policy expression")
+	}
+
+	root, err := p.or()
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy %q: %s", expr, err)
+	}
+
+	if tok, ok := p.peek(); ok {
+		return nil, fmt.Errorf("invalid policy %q: unexpected %q", expr, tok)
+	}
+
+	return &Policy{expr: expr, root: root}, nil
+}
+
+// Allows returns whether the token claims satisfy the policy.
+func (p *Policy) Allows(t *JSONWebToken) bool {
+	return p.root(t)
+}
+
+func (p *Policy) String() string {
+	return p.expr
+}
+
+// tokenizePolicy splits a policy expression on spaces and parentheses.
+func tokenizePolicy(expr string) []string {
+	expr = strings.Replace(expr, "(", " ( ", -1)
+	expr = strings.Replace(expr, ")", " ) ", -1)
+	return strings.Fields(expr)
+}
+
+// policyParser is a recursive descent parser of policy expressions.
+type policyParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *policyParser) peek() (string, bool) {
+	if p.pos >= len(p.tokens) {
+		return "", false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *policyParser) next() (string, bool) {
+	tok, ok := p.peek()
+	if ok {
+		p.pos++
+	}
+	return tok, ok
+}
+
+// or parses: and ("or" and)*
+func (p *policyParser) or() (policyNode, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if tok, ok := p.peek(); !ok || tok != "or" {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		left = func(t *JSONWebToken) bool { return l(t) || right(t) }
+	}
+}
+
+// and parses: not ("and" not)*
+func (p *policyParser) and() (policyNode, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if tok, ok := p.peek(); !ok || tok != "and" {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		left = func(t *JSONWebToken) bool { return l(t) && right(t) }
+	}
+}
+
+// not parses: "not" not | "(" or ")" | condition
+func (p *policyParser) not() (policyNode, error) {
+	tok, ok := p.next()
+	if !ok {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	switch tok {
+	case "not":
+		operand, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return func(t *JSONWebToken) bool { return !operand(t) }, nil
+	case "(":
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+
+		if tok, ok := p.next(); !ok || tok != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return inner, nil
+	case "email_verified":
+		return func(t *JSONWebToken) bool { return t.EmailVerified }, nil
+	}
+
+	conditions := map[string]func(value string) policyNode{
+		"scope": func(v string) policyNode { return func(t *JSONWebToken) bool { return t.HasScope(v) } },
+		"aud":   func(v string) policyNode { return func(t *JSONWebToken) bool { return t.IntendedFor(v) } },
+		"iss":   func(v string) policyNode { return func(t *JSONWebToken) bool { return t.Issuer == v } },
+		"sub":   func(v string) policyNode { return func(t *JSONWebToken) bool { return t.Subject == v } },
+		"email": func(v string) policyNode { return func(t *JSONWebToken) bool { return t.Email == v } },
+	}
+
+	condition, ok := conditions[tok]
+	if !ok {
+		return nil, fmt.Errorf("unknown condition %q", tok)
+	}
+
+	value, ok := p.next()
+	if !ok || value == "(" || value == ")" {
+		return nil, fmt.Errorf("%q requires a value", tok)
+	}
+	return condition(value), nil
+}
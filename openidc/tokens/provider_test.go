@@ -0,0 +1,133 @@
+package tokens
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+
+	"github.com/lift-plugins/auth/openidc/discovery"
+)
+
+// testIssuer is the issuer of testProvider.
+const testIssuer = "https://id.example.com"
+
+// memStore keeps documents in memory, encoded as JSON like the file store does.
+type memStore map[string][]byte
+
+func (m memStore) Read(name string, v interface{}) error {
+	data, ok := m[name]
+	if !ok {
+		return os.ErrNotExist
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (m memStore) Write(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	m[name] = data
+	return nil
+}
+
+func (m memStore) Delete(name string) error {
+	delete(m, name)
+	return nil
+}
+
+// testProvider is a fake OpenID provider whose configuration and signing keys are kept in store, as
+// if they were discovered. Tests serve the endpoints they need.
+type testProvider struct {
+	store  memStore
+	config *discovery.ProviderConfig
+	keys   *discovery.SigningKeys
+	// signers holds the private keys of the provider, by key ID.
+	signers map[string]jose.SigningKey
+}
+
+// newTestProvider stores the configuration of a provider signing ID tokens with ES256, whose token
+// endpoint is tokenEndpoint. It publishes no signing key until addKey is called.
+func newTestProvider(t *testing.T, tokenEndpoint string) *testProvider {
+	p := &testProvider{
+		store: make(memStore),
+		config: &discovery.ProviderConfig{
+			Issuer:         testIssuer,
+			TokenEndpoint:  tokenEndpoint,
+			JWKSURI:        testIssuer + "/jwks",
+			IDTokenSigAlgs: []string{"ES256"},
+		},
+		// Keys were just fetched, so unknown keys are not looked up again.
+		keys:    &discovery.SigningKeys{Keys: map[string]jose.JSONWebKey{}, FetchedAt: time.Now()},
+		signers: make(map[string]jose.SigningKey),
+	}
+
+	if err := p.config.Write(p.store); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.keys.Write(p.store); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// addKey generates a key signing with alg. It is published under kid, unless publish is false,
+// such as for keys of attackers.
+func (p *testProvider) addKey(t *testing.T, kid string, alg jose.SignatureAlgorithm, publish bool) {
+	key := newKey(t, alg)
+	p.signers[kid] = jose.SigningKey{Algorithm: alg, Key: jose.JSONWebKey{Key: key, KeyID: kid}}
+	if !publish {
+		return
+	}
+
+	p.keys.Keys[kid] = jose.JSONWebKey{Key: &key.PublicKey, KeyID: kid, Algorithm: string(alg), Use: "sig"}
+	if err := p.keys.Write(p.store); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sign returns a JWT with the given claims, signed with the key added as kid.
+func (p *testProvider) sign(t *testing.T, kid string, claims interface{}) string {
+	signer, err := jose.NewSigner(p.signers[kid], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// newKey generates an ECDSA key on the curve used by alg.
+func newKey(t *testing.T, alg jose.SignatureAlgorithm) *ecdsa.PrivateKey {
+	curves := map[jose.SignatureAlgorithm]elliptic.Curve{
+		jose.ES256: elliptic.P256(),
+		jose.ES384: elliptic.P384(),
+		jose.ES512: elliptic.P521(),
+	}
+
+	key, err := ecdsa.GenerateKey(curves[alg], rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
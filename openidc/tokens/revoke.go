@@ -0,0 +1,78 @@
+package tokens
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lift-plugins/auth/openidc/oauth2"
+	"github.com/pkg/errors"
+)
+
+// Revoke revokes a token with the provider's revocation endpoint, as specified in
+// https://tools.ietf.org/html/rfc7009. hint is the type of token, either "refresh_token"
+// or "access_token".
+func Revoke(ctx context.Context, endpoint, clientID, clientSecret, token, hint string) error {
+	if endpoint == "" {
+		return errors.New("identity provider does not support token revocation")
+	}
+
+	// The provider responds with 200 if the token was revoked or was already invalid.
+	resp, err := postToken(ctx, "revoking", endpoint, clientID, clientSecret, token, hint)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// postToken sends token to an endpoint taking tokens as form values, such as the revocation and
+// introspection ones, authenticating with the client credentials. action describes the request in
+// errors. Responses other than 200 are returned as errors, as *ProviderError if the provider sent
+// an OAuth2 error. Callers must close the body of the response returned.
+func postToken(ctx context.Context, action, endpoint, clientID, clientSecret, token, hint string) (*http.Response, error) {
+	formValues := url.Values{
+		"token":           {token},
+		"token_type_hint": {hint},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(formValues.Encode()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed preparing HTTP request")
+	}
+
+	req.SetBasicAuth(clientID, clientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oauth2.Do(ctx, req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed %s %s", action, hint)
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20)) // reads up to 1mb
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed reading response body")
+	}
+
+	tokenRes := new(tokenResponse)
+	if err := json.Unmarshal(body, tokenRes); err != nil || tokenRes.Error == "" {
+		return nil, fmt.Errorf("failed %s %s. HTTP status: %d", action, hint, resp.StatusCode)
+	}
+
+	return nil, &ProviderError{
+		Code:        tokenRes.Error,
+		Description: tokenRes.ErrorDescription,
+		URI:         tokenRes.ErrorURI,
+	}
+}
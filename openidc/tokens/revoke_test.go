@@ -0,0 +1,55 @@
+package tokens
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRevoke(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "client" || pass != "secret" {
+			t.Errorf("expected client credentials, got %q:%q", user, pass)
+		}
+
+		if token := r.PostFormValue("token"); token != "refresh" {
+			t.Errorf("unexpected token %q", token)
+		}
+
+		if hint := r.PostFormValue("token_type_hint"); hint != "refresh_token" {
+			t.Errorf("unexpected token type hint %q", hint)
+		}
+	}))
+	defer srv.Close()
+
+	if err := Revoke(context.Background(), srv.URL, "client", "secret", "refresh", "refresh_token"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRevokeErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.PostFormValue("token") {
+		case "unsupported":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "unsupported_token_type"}`))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	err := Revoke(context.Background(), srv.URL, "client", "secret", "unsupported", "access_token")
+	if perr, ok := err.(*ProviderError); !ok || perr.Code != "unsupported_token_type" {
+		t.Errorf("expected provider error, got %#v", err)
+	}
+
+	if err := Revoke(context.Background(), srv.URL, "client", "secret", "other", "access_token"); err == nil {
+		t.Error("expected an error for a failed response without an OAuth error")
+	}
+
+	if err := Revoke(context.Background(), "", "client", "secret", "other", "access_token"); err == nil {
+		t.Error("expected an error without a revocation endpoint")
+	}
+}
@@ -1,6 +1,8 @@
 package tokens
 
 import (
+	"context"
+	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/base64"
 	"encoding/json"
@@ -10,20 +12,23 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
 	"strings"
+	"time"
 
 	jose "gopkg.in/square/go-jose.v2"
 
-	"github.com/hooklift/lift/config"
 	"github.com/lift-plugins/auth/openidc/discovery"
 	"github.com/lift-plugins/auth/openidc/oauth2"
+	"github.com/lift-plugins/auth/openidc/store"
 	"github.com/pkg/errors"
 	uuid "github.com/satori/go.uuid"
 )
 
-var (
-	tokensPath = filepath.Join(config.WorkDir, "tokens.json")
+const (
+	// tokensFile is the name under which tokens are stored.
+	tokensFile = "tokens.json"
+	// lockFile is the name of the file locked while refreshing tokens.
+	lockFile = "tokens.lock"
 )
 
 // Tokens represents the tokens retrieved from the OpenID provider server.
@@ -32,39 +37,74 @@ type Tokens struct {
 	ID      string `json:"id,omitempty"`
 	Access  string `json:"access,omitempty"`
 	Refresh string `json:"refresh,omitempty"`
+	// ServiceIdentity marks tokens obtained through the client credentials grant, on behalf of
+	// a machine rather than a user.
+	ServiceIdentity bool `json:"service_identity,omitempty"`
+	// ExpiresAt is the access token expiration as reported by the token endpoint, in seconds
+	// since epoch. Only set for service identities.
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+	// Scope and Audience are the ones requested for a service identity, requested again when
+	// its tokens are renewed.
+	Scope    []string `json:"scope,omitempty"`
+	Audience []string `json:"audience,omitempty"`
 }
 
-// Read loads tokens from disk.
-func (tks *Tokens) Read() error {
-	data, err := ioutil.ReadFile(tokensPath)
-	if err != nil {
-		return errors.Wrapf(err, "failed reading tokens file at %q", tokensPath)
+// Read loads tokens from s.
+func (tks *Tokens) Read(s store.Store) error {
+	if err := s.Read(tokensFile, tks); err != nil {
+		return errors.Wrap(err, "failed reading tokens")
 	}
+	return nil
+}
 
-	if err := json.Unmarshal(data, &tks); err != nil {
-		return errors.Wrapf(err, "failed unmarshaling tokens file at %q", tokensPath)
+// Write stores tokens in s.
+func (tks *Tokens) Write(s store.Store) error {
+	if err := s.Write(tokensFile, tks); err != nil {
+		return errors.Wrap(err, "failed writing tokens")
 	}
 	return nil
 }
 
-// Write stores tokens to disk.
-func (tks *Tokens) Write() error {
-	data, err := json.MarshalIndent(tks, "", "\t")
+// WriteSession stores tokens of a new session in s. Tokens exchanged for the previous session
+// are discarded, so that they are not handed out on behalf of a different account.
+func (tks *Tokens) WriteSession(s store.Store) error {
+	release, err := store.Lock(s, exchangedLockFile)
 	if err != nil {
-		return errors.Wrap(err, "failed marshaling tokens")
+		return err
 	}
+	defer release()
 
-	if err := ioutil.WriteFile(tokensPath, data, os.FileMode(0600)); err != nil {
-		return errors.Wrapf(err, "failed writing tokens to %q", tokensPath)
+	if err := s.Delete(exchangedFile); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed deleting exchanged tokens")
+	}
+	return tks.Write(s)
+}
+
+// Secrets returns the ID, access and refresh tokens, so that stores can protect them. Access and ID
+// tokens are bearer credentials too, even if short-lived.
+func (tks *Tokens) Secrets() map[string]*string {
+	return map[string]*string{
+		"id":      &tks.ID,
+		"access":  &tks.Access,
+		"refresh": &tks.Refresh,
 	}
-	return nil
 }
 
 // Verify validates ID and Access tokens, according to:
 // http://openid.net/specs/openid-connect-core-1_0.html#rfc.section.3.1.3.7
 // http://openid.net/specs/openid-connect-core-1_0.html#ImplicitTokenValidation
-func (tks *Tokens) Verify(clientID, nonce string) error {
-	header, err := Verify(tks.ID)
+//
+// Signing keys are read from s. Claims that fail validation are reported as *ValidationError.
+func (tks *Tokens) Verify(ctx context.Context, s store.Store, clientID, nonce string, options ...VerifyOption) error {
+	opts := &verifyOptions{
+		maxIssuedAge: defaultMaxIssuedAge,
+		now:          time.Now,
+	}
+	for _, option := range options {
+		option(opts)
+	}
+
+	header, err := Verify(ctx, s, tks.ID)
 	if err != nil {
 		return err
 	}
@@ -74,112 +114,204 @@ func (tks *Tokens) Verify(clientID, nonce string) error {
 		return err
 	}
 
-	if idToken.Nonce != nonce {
-		return errors.New("ID token nonce does not match nonce value sent in request")
-	}
-
-	if idToken.Issuer != tks.Issuer {
-		return fmt.Errorf("issuer in ID token does not match the identity provider originally used: %s != %s", idToken.Issuer, tks.Issuer)
-	}
-
-	if idToken.AuthorizedParty != "" && idToken.AuthorizedParty != clientID {
-		return errors.New("authorized party in ID token does not match client ID")
-	}
-
-	if idToken.Expired() {
-		return errors.New("ID token has expired")
+	if err := validateClaims(idToken, tks.Issuer, clientID, nonce, opts); err != nil {
+		return err
 	}
 
 	if tks.Access != "" && idToken.AtHash != "" {
 		atHash := hash(tks.Access, header.Algorithm)
 		if atHash != idToken.AtHash {
-			return errors.New("calculated hash value from access token doesn't match value declared in ID token")
+			return invalidClaim("at_hash", "calculated hash value from access token doesn't match value declared in ID token")
 		}
 	}
 
 	return nil
 }
 
-// RefreshTokenResponse holds the response from the OpenIDC Provider when refreshing access tokens.
-type refreshTokenResponse struct {
+// tokenResponse holds the response from the OpenIDC Provider token endpoint.
+type tokenResponse struct {
 	AccessToken      string `json:"access_token"`
 	TokenType        string `json:"token_type"`
 	RefreshToken     string `json:"refresh_token"`
 	ExpiresIn        int    `json:"expires_in"`
 	IDToken          string `json:"id_token"`
+	Scope            string `json:"scope"`
+	IssuedTokenType  string `json:"issued_token_type"`
 	Error            string `json:"error"`
 	ErrorDescription string `json:"error_description"`
 	ErrorURI         string `json:"error_uri"`
 }
 
+// ProviderError is an OAuth2 error response returned by the OpenIDC Provider.
+// https://tools.ietf.org/html/rfc6749#section-5.2
+type ProviderError struct {
+	Code        string
+	Description string
+	URI         string
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("%s: %s. %s", e.Code, e.Description, e.URI)
+}
+
+// requestTokens sends a token request to the provider's token endpoint, authenticating
+// with the given client credentials. OAuth2 errors are returned as *ProviderError.
+func requestTokens(ctx context.Context, endpoint, clientID, clientSecret string, formValues url.Values) (*tokenResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(formValues.Encode()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed preparing HTTP request")
+	}
+
+	req.SetBasicAuth(clientID, clientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := oauth2.Do(ctx, req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed requesting tokens")
+	}
+	defer resp.Body.Close()
+
+	tokenRes := new(tokenResponse)
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20)) // reads up to 1mb
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed reading response body")
+	}
+
+	if err := json.Unmarshal(body, tokenRes); err != nil {
+		return nil, errors.Wrapf(err, "failed unmarshaling response: %s", string(body[:]))
+	}
+
+	if tokenRes.Error != "" {
+		return nil, &ProviderError{
+			Code:        tokenRes.Error,
+			Description: tokenRes.ErrorDescription,
+			URI:         tokenRes.ErrorURI,
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed requesting tokens. HTTP status: %d", resp.StatusCode)
+	}
+
+	return tokenRes, nil
+}
+
 // RefreshToken refreshes ID, Access and Refresh tokens using current refresh token. Only if any of the tokens expired.
-func (tks *Tokens) RefreshToken(clientID, clientSecret string) error {
+// Service identities have no refresh token, so the client credentials grant is run again instead.
+//
+// Tokens are read from and written to s. Refreshing is serialized across processes through a lock
+// taken on s. The provider rotates refresh tokens on every use, so a process refreshing concurrently
+// would otherwise persist a revoked refresh token.
+func (tks *Tokens) RefreshToken(ctx context.Context, s store.Store, clientID, clientSecret string) error {
 	if tks.Access == "" {
 		return errors.New("there is no access token to refresh")
 	}
 
-	if tks.Refresh == "" {
-		return errors.New("no refresh token found")
+	expired, err := tks.expired()
+	if err != nil || !expired {
+		return err
 	}
 
-	accessToken, err := Decode(tks.Access)
+	release, err := store.Lock(s, lockFile)
 	if err != nil {
 		return err
 	}
+	defer release()
 
-	idToken, err := Decode(tks.ID)
+	// Another process may have refreshed the tokens while we were waiting for the lock.
+	current := new(Tokens)
+	if err := current.Read(s); err != nil {
+		return err
+	}
+	*tks = *current
+
+	if expired, err := tks.expired(); err != nil || !expired {
+		return err
+	}
+
+	if tks.ServiceIdentity {
+		return tks.refreshServiceIdentity(ctx, s, clientID, clientSecret)
+	}
+	return tks.refresh(ctx, s, clientID, clientSecret)
+}
+
+// ForceRefresh refreshes tokens even if they did not expire, such as after the server rejected them.
+// If another process refreshed them in the meantime, the tokens stored in s are loaded instead.
+func (tks *Tokens) ForceRefresh(ctx context.Context, s store.Store, clientID, clientSecret string) error {
+	release, err := store.Lock(s, lockFile)
 	if err != nil {
 		return err
 	}
+	defer release()
+
+	current := new(Tokens)
+	if err := current.Read(s); err != nil {
+		return err
+	}
 
-	if !accessToken.Expired() && !idToken.Expired() {
+	refreshed := current.Access != tks.Access
+	*tks = *current
+	if refreshed {
 		return nil
 	}
 
-	config := new(discovery.ProviderConfig)
-	if err := config.Read(); err != nil {
-		return err
+	if tks.ServiceIdentity {
+		return tks.refreshServiceIdentity(ctx, s, clientID, clientSecret)
 	}
+	return tks.refresh(ctx, s, clientID, clientSecret)
+}
 
-	nonce := uuid.NewV4().String()
-	formValues := url.Values{
-		"grant_type":    {"refresh_token"},
-		"refresh_token": {tks.Refresh},
-		"scope":         {strings.Join(accessToken.Scope, " ")},
-		"state":         {nonce},
+// expired returns whether any of the tokens expired.
+func (tks *Tokens) expired() (bool, error) {
+	if tks.ServiceIdentity {
+		return tks.serviceExpired(), nil
 	}
 
-	req, err := http.NewRequest(http.MethodPost, config.TokenEndpoint, strings.NewReader(formValues.Encode()))
+	accessToken, err := Decode(tks.Access)
 	if err != nil {
-		return errors.Wrapf(err, "failed preparing HTTP request")
+		return false, err
 	}
 
-	req.SetBasicAuth(clientID, clientSecret)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	resp, err := oauth2.Client.Do(req)
+	idToken, err := Decode(tks.ID)
 	if err != nil {
-		return errors.Wrapf(err, "failed refreshing access token")
+		return false, err
 	}
-	defer resp.Body.Close()
 
-	refreshRes := new(refreshTokenResponse)
-	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20)) // reads up to 1mb
+	return accessToken.Expired() || idToken.Expired(), nil
+}
+
+// refresh gets new tokens using the current refresh token and persists them in s.
+func (tks *Tokens) refresh(ctx context.Context, s store.Store, clientID, clientSecret string) error {
+	if tks.Refresh == "" {
+		return errors.New("no refresh token found")
+	}
+
+	accessToken, err := Decode(tks.Access)
 	if err != nil {
-		return errors.Wrapf(err, "failed reading response body")
+		return err
 	}
 
-	if err := json.Unmarshal(body, refreshRes); err != nil {
-		return errors.Wrapf(err, "failed unmarshaling response: %s", string(body[:]))
+	config := new(discovery.ProviderConfig)
+	if err := config.Read(s); err != nil {
+		return err
+	}
+
+	nonce := uuid.NewV4().String()
+	formValues := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {tks.Refresh},
+		"scope":         {strings.Join(accessToken.Scope, " ")},
+		"state":         {nonce},
 	}
 
-	if refreshRes.Error != "" {
-		return fmt.Errorf("%s: %s. %s", refreshRes.Error, refreshRes.ErrorDescription, refreshRes.ErrorURI)
+	refreshRes, err := requestTokens(ctx, config.TokenEndpoint, clientID, clientSecret, formValues)
+	if err != nil {
+		return errors.Wrapf(err, "failed refreshing access token")
 	}
 
 	// Refreshes identity provider configuration and keys. Making sure we retrieved new
 	// signing keys that may have been generated.
-	if err := discovery.Run(config.Issuer); err != nil {
+	if err := discovery.Run(ctx, s, config.Issuer); err != nil {
 		return errors.Wrapf(err, "failed refreshing provider configuration from %q", config.Issuer)
 	}
 
@@ -189,11 +321,11 @@ func (tks *Tokens) RefreshToken(clientID, clientSecret string) error {
 	newTokens.Refresh = refreshRes.RefreshToken
 	newTokens.Issuer = config.Issuer
 
-	if err := newTokens.Verify(clientID, nonce); err != nil {
+	if err := newTokens.Verify(ctx, s, clientID, nonce); err != nil {
 		return err
 	}
 
-	if err := newTokens.Write(); err != nil {
+	if err := newTokens.Write(s); err != nil {
 		return err
 	}
 
@@ -206,26 +338,30 @@ func (tks *Tokens) RefreshToken(clientID, clientSecret string) error {
 }
 
 // hash helps prevent token substitution attacks by hashing a given token value and
-// returning the left-most half of it.
+// returning the base64url encoding of the left-most half of it. The hash function is the one
+// used by the signature algorithm of the ID token.
 // This function behaves as specified in the OpenID Connect spec for calculating
-// at_hash and c_hash values. http://openid.net/specs/openid-connect-core-1_0.html#rfc.section.16.11
+// at_hash and c_hash values. http://openid.net/specs/openid-connect-core-1_0.html#rfc.section.3.3.2.11
 func hash(token string, alg string) string {
 	var leftMostHalf []byte
 	switch jose.SignatureAlgorithm(alg) {
-	case jose.ES384, jose.RS384:
+	case jose.ES384, jose.RS384, jose.PS384:
 		sum := sha512.Sum384([]byte(token))
 		leftMostHalf = sum[:(len(sum) / 2)]
-	case jose.ES512, jose.RS512:
+	case jose.ES512, jose.RS512, jose.PS512:
 		sum := sha512.Sum512([]byte(token))
 		leftMostHalf = sum[:(len(sum) / 2)]
 	default:
-		sum := sha512.Sum512_256([]byte(token))
+		sum := sha256.Sum256([]byte(token))
 		leftMostHalf = sum[:(len(sum) / 2)]
 	}
-	return base64.StdEncoding.EncodeToString(leftMostHalf)
+	return base64.RawURLEncoding.EncodeToString(leftMostHalf)
 }
 
-// Delete removes all the tokens cached on disk.
-func Delete() error {
-	return os.Remove(tokensPath)
+// Delete removes all the tokens cached in s, including exchanged tokens.
+func Delete(s store.Store) error {
+	if err := s.Delete(exchangedFile); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed deleting exchanged tokens")
+	}
+	return s.Delete(tokensFile)
 }
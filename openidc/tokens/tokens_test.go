@@ -1,9 +1,184 @@
 package tokens
 
-import "testing"
+import (
+	"encoding/base64"
+	"os"
+	"reflect"
+	"testing"
+	"time"
 
-func TestDecode(t *testing.T)   {}
-func TestHash(t *testing.T)     {}
-func TestValidate(t *testing.T) {}
-func TestWrite(t *testing.T)    {}
-func TestRead(t *testing.T)     {}
+	"github.com/pkg/errors"
+)
+
+func TestDecode(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"iss": "https://id.hooklift.io", "sub": "user", "aud": "client", "exp": 1500003600}`))
+	jwt, err := Decode("e30." + payload + ".sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &JSONWebToken{Issuer: "https://id.hooklift.io", Subject: "user", Audience: Audience{"client"}, Expires: 1500003600}
+	if !reflect.DeepEqual(jwt, expected) {
+		t.Errorf("expected %+v, got %+v", expected, jwt)
+	}
+
+	for _, token := range []string{"opaque", "e30.not base64.sig", "e30." + base64.RawURLEncoding.EncodeToString([]byte("[]")) + ".sig"} {
+		if _, err := Decode(token); err == nil {
+			t.Errorf("expected error decoding %q", token)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	// Examples from http://openid.net/specs/openid-connect-core-1_0.html#id_tokenExample
+	tests := []struct {
+		token, alg, hash string
+	}{
+		{"jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y", "RS256", "77QmUPtjPfzWtF2AnpK9RQ"},
+		{"Qcb0Orv1zh30vL1MPRsbm-diHiMwcLyZvn1arpZv-Jxf_11jnpEX3Tgfvk", "RS256", "LDktKdoQak3Pk0cnXxCltA"},
+		// The hash function is the one of the signature algorithm, whatever its family.
+		{"jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y", "ES256", "77QmUPtjPfzWtF2AnpK9RQ"},
+		{"jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y", "PS256", "77QmUPtjPfzWtF2AnpK9RQ"},
+		{"jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y", "RS384", "jtAeDp945y1dDqU3nkIVGNZP1HjH_MFs"},
+		{"jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y", "ES384", "jtAeDp945y1dDqU3nkIVGNZP1HjH_MFs"},
+		{"jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y", "PS384", "jtAeDp945y1dDqU3nkIVGNZP1HjH_MFs"},
+		{"jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y", "RS512", "q7nS86GgvvFaZkzALLWqJYaJIKw2wCDAVfCAsm5CrBM"},
+		{"jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y", "ES512", "q7nS86GgvvFaZkzALLWqJYaJIKw2wCDAVfCAsm5CrBM"},
+		{"jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y", "PS512", "q7nS86GgvvFaZkzALLWqJYaJIKw2wCDAVfCAsm5CrBM"},
+	}
+
+	for _, tt := range tests {
+		if h := hash(tt.token, tt.alg); h != tt.hash {
+			t.Errorf("%s %s: expected %q, got %q", tt.alg, tt.token, tt.hash, h)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	valid := func() *JSONWebToken {
+		return &JSONWebToken{
+			Issuer:   "https://id.hooklift.io",
+			Audience: Audience{"client"},
+			Expires:  now.Add(time.Hour).Unix(),
+			IssuedAt: now.Add(-time.Minute).Unix(),
+			AuthTime: now.Add(-time.Minute).Unix(),
+			Nonce:    "nonce",
+		}
+	}
+
+	tests := []struct {
+		desc   string
+		modify func(*JSONWebToken)
+		opts   []VerifyOption
+		claim  string
+	}{
+		{"valid", func(t *JSONWebToken) {}, nil, ""},
+		{"wrong issuer", func(t *JSONWebToken) { t.Issuer = "https://evil.io" }, nil, "iss"},
+		{"missing audience", func(t *JSONWebToken) { t.Audience = Audience{"other"} }, nil, "aud"},
+		{"multiple audiences without azp", func(t *JSONWebToken) { t.Audience = Audience{"client", "other"} }, nil, "azp"},
+		{"multiple audiences with azp", func(t *JSONWebToken) {
+			t.Audience = Audience{"client", "other"}
+			t.AuthorizedParty = "client"
+		}, nil, ""},
+		{"wrong azp", func(t *JSONWebToken) { t.AuthorizedParty = "other" }, nil, "azp"},
+		{"expired", func(t *JSONWebToken) { t.Expires = now.Add(-time.Minute).Unix() }, nil, "exp"},
+		{"not before in the future", func(t *JSONWebToken) { t.NotBefore = now.Add(time.Minute).Unix() }, nil, "nbf"},
+		{"issued in the future", func(t *JSONWebToken) { t.IssuedAt = now.Add(time.Minute).Unix() }, nil, "iat"},
+		{"issued too long ago", func(t *JSONWebToken) { t.IssuedAt = now.Add(-time.Hour).Unix() }, nil, "iat"},
+		{"issued long ago with larger window", func(t *JSONWebToken) {
+			t.IssuedAt = now.Add(-time.Hour).Unix()
+		}, []VerifyOption{WithMaxIssuedAge(2 * time.Hour)}, ""},
+		{"wrong nonce", func(t *JSONWebToken) { t.Nonce = "other" }, nil, "nonce"},
+		{"missing auth_time with max_age", func(t *JSONWebToken) { t.AuthTime = 0 }, []VerifyOption{WithMaxAge(time.Hour)}, "auth_time"},
+		{"auth_time older than max_age", func(t *JSONWebToken) {
+			t.AuthTime = now.Add(-2 * time.Hour).Unix()
+		}, []VerifyOption{WithMaxAge(time.Hour)}, "auth_time"},
+	}
+
+	for _, tt := range tests {
+		token := valid()
+		tt.modify(token)
+
+		opts := &verifyOptions{
+			maxIssuedAge: defaultMaxIssuedAge,
+			now:          func() time.Time { return now },
+		}
+		for _, option := range tt.opts {
+			option(opts)
+		}
+
+		err := validateClaims(token, "https://id.hooklift.io", "client", "nonce", opts)
+		if tt.claim == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			}
+			continue
+		}
+
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("%s: expected *ValidationError, got %#v", tt.desc, err)
+			continue
+		}
+
+		if verr.Claim != tt.claim {
+			t.Errorf("%s: expected failure on %q, got %q", tt.desc, tt.claim, verr.Claim)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	s := make(memStore)
+	tks := &Tokens{Issuer: "https://id.hooklift.io", ID: "id", Access: "access", Refresh: "refresh"}
+	if err := tks.Write(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s[tokensFile]; !ok {
+		t.Errorf("expected tokens to be written to %q", tokensFile)
+	}
+}
+
+func TestRead(t *testing.T) {
+	s := make(memStore)
+	if err := new(Tokens).Read(s); !os.IsNotExist(errors.Cause(err)) {
+		t.Errorf("expected missing tokens to be reported, got %v", err)
+	}
+
+	tks := &Tokens{Issuer: "https://id.hooklift.io", ID: "id", Access: "access", Refresh: "refresh", ServiceIdentity: true, ExpiresAt: 1500003600}
+	if err := tks.Write(s); err != nil {
+		t.Fatal(err)
+	}
+
+	read := new(Tokens)
+	if err := read.Read(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(read, tks) {
+		t.Errorf("expected %+v, got %+v", tks, read)
+	}
+}
+
+func TestSecrets(t *testing.T) {
+	tks := &Tokens{Issuer: "https://id.hooklift.io", ID: "id", Access: "access", Refresh: "refresh"}
+	secrets := tks.Secrets()
+	for key, value := range map[string]string{"id": "id", "access": "access", "refresh": "refresh"} {
+		if field, ok := secrets[key]; !ok || *field != value {
+			t.Errorf("expected %s token to be a secret", key)
+		}
+	}
+
+	exchanged := &exchangedTokens{Tokens: map[string]*ExchangedToken{
+		"api read": {Access: "exchanged-access", Refresh: "exchanged-refresh"},
+	}}
+	secrets = exchanged.Secrets()
+	if field, ok := secrets["access api read"]; !ok || *field != "exchanged-access" {
+		t.Error("expected exchanged access token to be a secret")
+	}
+
+	if field, ok := secrets["refresh api read"]; !ok || *field != "exchanged-refresh" {
+		t.Error("expected exchanged refresh token to be a secret")
+	}
+}
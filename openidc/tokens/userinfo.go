@@ -0,0 +1,137 @@
+package tokens
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	jose "gopkg.in/square/go-jose.v2"
+
+	"github.com/lift-plugins/auth/openidc/discovery"
+	"github.com/lift-plugins/auth/openidc/oauth2"
+	"github.com/lift-plugins/auth/openidc/store"
+	"github.com/pkg/errors"
+)
+
+// UserInfo holds the claims about the authenticated user returned by the UserInfo endpoint.
+// http://openid.net/specs/openid-connect-core-1_0.html#UserInfo
+type UserInfo struct {
+	Subject       string `json:"sub"`
+	Name          string `json:"name,omitempty"`
+	GivenName     string `json:"given_name,omitempty"`
+	FamilyName    string `json:"family_name,omitempty"`
+	Picture       string `json:"picture,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified,omitempty"`
+}
+
+// UserInfo requests claims about the user from the provider's UserInfo endpoint, using the access
+// token. Responses signed by the provider are verified with the signing keys read from s, and must be
+// issued by the provider for clientID. The subject returned must match the one in the ID token, as
+// required by http://openid.net/specs/openid-connect-core-1_0.html#UserInfoResponse
+func (tks *Tokens) UserInfo(ctx context.Context, s store.Store, clientID, endpoint string) (*UserInfo, error) {
+	if endpoint == "" {
+		return nil, errors.New("identity provider does not support the UserInfo endpoint")
+	}
+
+	idToken, err := Decode(tks.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed preparing HTTP request")
+	}
+
+	req.Header.Set("Authorization", "Bearer "+tks.Access)
+	req.Header.Set("Accept", "application/json, application/jwt")
+
+	resp, err := oauth2.Do(ctx, req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed requesting user info")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		if challenge := resp.Header.Get("WWW-Authenticate"); challenge != "" {
+			return nil, fmt.Errorf("failed requesting user info. HTTP status: %d, %s", resp.StatusCode, challenge)
+		}
+		return nil, fmt.Errorf("failed requesting user info. HTTP status: %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20)) // reads up to 1mb
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed reading response body")
+	}
+
+	info := new(UserInfo)
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "application/jwt" {
+		token := string(body)
+		if err := tks.verifyUserInfo(ctx, s, clientID, token); err != nil {
+			return nil, errors.Wrap(err, "failed verifying signed user info")
+		}
+
+		if err := decodePayload(token, info); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(body, info); err != nil {
+		return nil, errors.Wrapf(err, "failed decoding user info")
+	}
+
+	if info.Subject != idToken.Subject {
+		return nil, invalidClaim("sub", "user info subject %q does not match ID token subject %q", info.Subject, idToken.Subject)
+	}
+
+	return info, nil
+}
+
+// verifyUserInfo checks the signature of a signed UserInfo response, and that it was issued by the
+// provider for clientID. The UserInfo signing algorithm is registered separately from the ID token one,
+// so any supported asymmetric algorithm is accepted.
+// http://openid.net/specs/openid-connect-core-1_0.html#UserInfoResponse
+func (tks *Tokens) verifyUserInfo(ctx context.Context, s store.Store, clientID, token string) error {
+	config := new(discovery.ProviderConfig)
+	if err := config.Read(s); err != nil {
+		return err
+	}
+
+	keys := new(discovery.SigningKeys)
+	if err := keys.Read(s); err != nil {
+		return err
+	}
+
+	_, err := VerifyWith(token, SupportedAlgorithms(), func(kid string) (jose.JSONWebKey, error) {
+		return keys.Lookup(ctx, s, kid, config.JWKSURI)
+	})
+	if err != nil {
+		return err
+	}
+
+	claims := new(struct {
+		Issuer   string   `json:"iss"`
+		Audience Audience `json:"aud"`
+	})
+	if err := decodePayload(token, claims); err != nil {
+		return err
+	}
+
+	issuer := tks.Issuer
+	if issuer == "" {
+		issuer = config.Issuer
+	}
+
+	if claims.Issuer != issuer {
+		return invalidClaim("iss", "user info issuer %q does not match %q", claims.Issuer, issuer)
+	}
+
+	if !claims.Audience.Contains(clientID) {
+		return invalidClaim("aud", "user info is not intended for client %q", clientID)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package tokens
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jose "gopkg.in/square/go-jose.v2"
+
+	"github.com/pkg/errors"
+)
+
+func TestUserInfo(t *testing.T) {
+	idToken := "e30." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user"}`)) + ".sig"
+
+	tests := []struct {
+		desc    string
+		subject string
+		err     bool
+	}{
+		{"matching subject", "user", false},
+		{"different subject", "other", true},
+	}
+
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer access" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"sub":%q,"email":"user@example.com","email_verified":true}`, tt.subject)
+		}))
+
+		tks := &Tokens{ID: idToken, Access: "access"}
+		info, err := tks.UserInfo(context.Background(), nil, "client", srv.URL)
+		srv.Close()
+
+		if tt.err {
+			if _, ok := err.(*ValidationError); !ok {
+				t.Errorf("%s: expected *ValidationError, got %v", tt.desc, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+
+		if info.Email != "user@example.com" || !info.EmailVerified {
+			t.Errorf("%s: unexpected user info: %+v", tt.desc, info)
+		}
+	}
+}
+
+func TestSignedUserInfo(t *testing.T) {
+	// The provider signs ID tokens with ES256 but user info with ES384, as allowed by
+	// userinfo_signed_response_alg.
+	p := newTestProvider(t, "")
+	p.addKey(t, "userinfo", jose.ES384, true)
+
+	idToken := "e30." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user"}`)) + ".sig"
+	tests := []struct {
+		desc   string
+		claims map[string]interface{}
+		claim  string
+	}{
+		{"valid", map[string]interface{}{"iss": "https://id.example.com", "aud": "client", "sub": "user", "name": "User"}, ""},
+		{"audience list", map[string]interface{}{"iss": "https://id.example.com", "aud": []string{"other", "client"}, "sub": "user", "name": "User"}, ""},
+		{"other issuer", map[string]interface{}{"iss": "https://evil.example.com", "aud": "client", "sub": "user"}, "iss"},
+		{"other audience", map[string]interface{}{"iss": "https://id.example.com", "aud": "other", "sub": "user"}, "aud"},
+		{"missing audience", map[string]interface{}{"iss": "https://id.example.com", "sub": "user"}, "aud"},
+	}
+
+	for _, tt := range tests {
+		token := p.sign(t, "userinfo", tt.claims)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/jwt")
+			fmt.Fprint(w, token)
+		}))
+
+		tks := &Tokens{Issuer: "https://id.example.com", ID: idToken, Access: "access"}
+		info, err := tks.UserInfo(context.Background(), p.store, "client", srv.URL)
+		srv.Close()
+
+		if tt.claim != "" {
+			if verr, ok := errors.Cause(err).(*ValidationError); !ok || verr.Claim != tt.claim {
+				t.Errorf("%s: expected invalid %s claim, got %v", tt.desc, tt.claim, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+
+		if info.Name != "User" {
+			t.Errorf("%s: unexpected user info: %+v", tt.desc, info)
+		}
+	}
+}
+
+func TestSignedUserInfoUnknownKey(t *testing.T) {
+	p := newTestProvider(t, "")
+	p.addKey(t, "forged", jose.ES256, false)
+
+	token := p.sign(t, "forged", map[string]interface{}{"iss": "https://id.example.com", "aud": "client", "sub": "user"})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/jwt; charset=utf-8")
+		fmt.Fprint(w, token)
+	}))
+	defer srv.Close()
+
+	idToken := "e30." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user"}`)) + ".sig"
+	tks := &Tokens{Issuer: "https://id.example.com", ID: idToken, Access: "access"}
+	if _, err := tks.UserInfo(context.Background(), p.store, "client", srv.URL); err == nil {
+		t.Error("expected user info signed with an unknown key to be rejected")
+	}
+}
@@ -0,0 +1,116 @@
+package tokens
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultMaxIssuedAge is how far in the past the iat claim of an ID token is accepted by default.
+const defaultMaxIssuedAge = 10 * time.Minute
+
+// ValidationError is returned when an ID token claim fails validation.
+type ValidationError struct {
+	// Claim is the name of the claim that failed validation, such as "aud" or "nbf".
+	Claim string
+	// Reason describes why the claim is not valid.
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %q claim in ID token: %s", e.Claim, e.Reason)
+}
+
+func invalidClaim(claim, format string, args ...interface{}) error {
+	return &ValidationError{
+		Claim:  claim,
+		Reason: fmt.Sprintf(format, args...),
+	}
+}
+
+// verifyOptions holds optional settings for validating ID tokens.
+type verifyOptions struct {
+	maxIssuedAge time.Duration
+	maxAge       time.Duration
+	now          func() time.Time
+}
+
+// VerifyOption configures how ID tokens are validated.
+type VerifyOption func(*verifyOptions)
+
+// WithMaxIssuedAge sets how far in the past the ID token iat claim is accepted. It defaults to 10 minutes.
+func WithMaxIssuedAge(d time.Duration) VerifyOption {
+	return func(o *verifyOptions) {
+		o.maxIssuedAge = d
+	}
+}
+
+// WithMaxAge sets the max_age value that was sent in the authentication request. The ID token is then
+// required to have an auth_time claim no older than max age.
+func WithMaxAge(d time.Duration) VerifyOption {
+	return func(o *verifyOptions) {
+		o.maxAge = d
+	}
+}
+
+// validateClaims checks ID token claims according to
+// http://openid.net/specs/openid-connect-core-1_0.html#rfc.section.3.1.3.7
+func validateClaims(idToken *JSONWebToken, issuer, clientID, nonce string, opts *verifyOptions) error {
+	now := opts.now()
+
+	if idToken.Issuer != issuer {
+		return invalidClaim("iss", "it does not match the identity provider originally used: %s != %s", idToken.Issuer, issuer)
+	}
+
+	if !idToken.Audience.Contains(clientID) {
+		return invalidClaim("aud", "client ID %q is not an intended audience", clientID)
+	}
+
+	if len(idToken.Audience) > 1 && idToken.AuthorizedParty == "" {
+		return invalidClaim("azp", "it is required when there are multiple audiences")
+	}
+
+	if idToken.AuthorizedParty != "" && idToken.AuthorizedParty != clientID {
+		return invalidClaim("azp", "authorized party does not match client ID")
+	}
+
+	if idToken.Expires == 0 {
+		return invalidClaim("exp", "it is required")
+	}
+
+	if now.After(time.Unix(idToken.Expires, 0).Add(leeway)) {
+		return invalidClaim("exp", "ID token has expired")
+	}
+
+	if idToken.NotBefore != 0 && now.Add(leeway).Before(time.Unix(idToken.NotBefore, 0)) {
+		return invalidClaim("nbf", "ID token is not valid yet")
+	}
+
+	if idToken.IssuedAt == 0 {
+		return invalidClaim("iat", "it is required")
+	}
+
+	issuedAt := time.Unix(idToken.IssuedAt, 0)
+	if now.Add(leeway).Before(issuedAt) {
+		return invalidClaim("iat", "ID token was issued in the future")
+	}
+
+	if opts.maxIssuedAge > 0 && now.Sub(issuedAt) > opts.maxIssuedAge+leeway {
+		return invalidClaim("iat", "ID token was issued more than %s ago", opts.maxIssuedAge)
+	}
+
+	if idToken.Nonce != nonce {
+		return invalidClaim("nonce", "it does not match nonce value sent in request")
+	}
+
+	if opts.maxAge > 0 {
+		if idToken.AuthTime == 0 {
+			return invalidClaim("auth_time", "it is required when max_age is requested")
+		}
+
+		if now.Sub(time.Unix(idToken.AuthTime, 0)) > opts.maxAge+leeway {
+			return invalidClaim("auth_time", "user authenticated more than %s ago", opts.maxAge)
+		}
+	}
+
+	return nil
+}
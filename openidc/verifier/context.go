@@ -0,0 +1,20 @@
+package verifier
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/lift-plugins/auth/openidc/tokens"
+)
+
+type claimsKey struct{}
+
+// NewContext returns a copy of ctx holding the verified claims of an access token.
+func NewContext(ctx context.Context, claims *tokens.JSONWebToken) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// FromContext returns the verified claims of the access token the request was made with, if any.
+func FromContext(ctx context.Context) (*tokens.JSONWebToken, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*tokens.JSONWebToken)
+	return claims, ok
+}
@@ -0,0 +1,69 @@
+package verifier
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// UnaryServerInterceptor verifies the access token sent in the authorization metadata of each call,
+// and checks that it was granted the scopes required by the method. Methods are keyed by their full
+// name, such as "/hooklift.apps.Apps/Deploy", methods not listed only require a valid token. Verified
+// claims are available to handlers through FromContext.
+func (v *Verifier) UnaryServerInterceptor(methodScopes map[string][]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := v.authenticate(ctx, methodScopes[info.FullMethod])
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is like UnaryServerInterceptor, for streaming methods.
+func (v *Verifier) StreamServerInterceptor(methodScopes map[string][]string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := v.authenticate(ss.Context(), methodScopes[info.FullMethod])
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authenticate verifies the access token of a call, returning a context holding its claims.
+func (v *Verifier) authenticate(ctx context.Context, scopes []string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var authorization string
+	if values := md["authorization"]; len(values) > 0 {
+		authorization = values[0]
+	}
+
+	token, err := bearerToken(authorization)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "%s", err)
+	}
+
+	claims, err := v.Verify(ctx, token)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "%s", err)
+	}
+
+	if err := v.authorize(claims, scopes); err != nil {
+		return nil, grpc.Errorf(codes.PermissionDenied, "%s", err)
+	}
+
+	return NewContext(ctx, claims), nil
+}
+
+// serverStream overrides the context of a grpc.ServerStream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
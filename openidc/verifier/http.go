@@ -0,0 +1,39 @@
+package verifier
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Middleware returns net/http middleware that verifies the Bearer access token of each request,
+// and checks that it was granted the given scopes. Errors are reported as specified in
+// https://tools.ietf.org/html/rfc6750#section-3. Verified claims are available to handlers through
+// FromContext.
+func (v *Verifier) Middleware(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, err := bearerToken(r.Header.Get("Authorization"))
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			claims, err := v.Verify(r.Context(), token)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer error=\"invalid_token\", error_description=%q", err.Error()))
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			if err := v.authorize(claims, scopes); err != nil {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer error=\"insufficient_scope\", scope=%q", strings.Join(scopes, " ")))
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), claims)))
+		})
+	}
+}
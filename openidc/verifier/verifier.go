@@ -0,0 +1,318 @@
+// Package verifier validates Bearer access tokens issued by an OpenID Provider, for services
+// receiving requests from Lift users. It provides gRPC interceptors and net/http middleware.
+package verifier
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+
+	"github.com/lift-plugins/auth/openidc/discovery"
+	"github.com/lift-plugins/auth/openidc/oauth2"
+	"github.com/lift-plugins/auth/openidc/tokens"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// leeway is used to avoid rejecting tokens due to client and server time mismatches.
+	leeway = 10 * time.Second
+
+	// refetchInterval is the minimum time between fetches of signing keys triggered by unknown key IDs.
+	// It keeps tokens with forged key IDs from making us flood the provider with requests.
+	refetchInterval = 5 * time.Minute
+)
+
+// TokenError is returned when an access token is not valid.
+type TokenError struct {
+	Reason string
+}
+
+func (e *TokenError) Error() string {
+	return "invalid access token: " + e.Reason
+}
+
+// ScopeError is returned when a valid access token lacks required scopes.
+type ScopeError struct {
+	// Missing holds the required scopes not granted to the token.
+	Missing []string
+}
+
+func (e *ScopeError) Error() string {
+	return fmt.Sprintf("access token lacks required scopes: %s", strings.Join(e.Missing, " "))
+}
+
+// Verifier validates access tokens issued by a provider for an audience. Signing keys are fetched
+// from the provider and kept up to date, following its caching headers and key rotations. It is
+// safe for concurrent use.
+//
+// Verifiers run in servers, so they ignore dev mode: provider configuration is always validated
+// strictly, and the development certificate is not trusted.
+type Verifier struct {
+	issuer     string
+	audience   string
+	jwksURI    string
+	allowed    []string
+	httpClient *http.Client
+	now        func() time.Time
+
+	// hierarchical tells whether scopes are matched as described in tokens.JSONWebToken.HasScope.
+	hierarchical bool
+
+	mu   sync.RWMutex
+	keys *discovery.SigningKeys
+	// fetches deduplicates concurrent fetches of signing keys.
+	fetches singleflight.Group
+}
+
+// Option configures a Verifier.
+type Option func(*Verifier)
+
+// WithAlgorithms sets the signature algorithms accepted for access tokens. Defaults to all the
+// asymmetric algorithms returned by tokens.SupportedAlgorithms. Symmetric algorithms, such as HS256,
+// and "none" are not supported.
+func WithAlgorithms(algs ...string) Option {
+	return func(v *Verifier) {
+		v.allowed = algs
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to fetch provider configuration and signing keys.
+// Defaults to a client with a 30 seconds timeout that does not follow redirects.
+func WithHTTPClient(client *http.Client) Option {
+	return func(v *Verifier) {
+		v.httpClient = client
+	}
+}
+
+// WithHierarchicalScopes makes the Verifier grant scopes below, or matched by wildcards in, the
+// scopes of access tokens, as described in tokens.JSONWebToken.HasScope. By default, required scopes
+// must be granted exactly, as in Authorize.
+func WithHierarchicalScopes() Option {
+	return func(v *Verifier) {
+		v.hierarchical = true
+	}
+}
+
+// New returns a Verifier accepting tokens issued by issuer for audience. Provider configuration
+// and signing keys are fetched right away.
+func New(issuer, audience string, options ...Option) (*Verifier, error) {
+	if audience == "" {
+		return nil, errors.New("an audience is required to verify access tokens")
+	}
+
+	v := &Verifier{
+		audience: audience,
+		allowed:  tokens.SupportedAlgorithms(),
+		now:      time.Now,
+	}
+	for _, option := range options {
+		option(v)
+	}
+
+	if err := checkAlgorithms(v.allowed); err != nil {
+		return nil, err
+	}
+
+	if v.httpClient == nil {
+		v.httpClient = &http.Client{
+			Timeout: 30 * time.Second,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+
+	ctx := oauth2.NewContext(context.Background(), v.httpClient)
+	config := new(discovery.ProviderConfig)
+	if err := config.FetchStrict(ctx, issuer); err != nil {
+		return nil, err
+	}
+
+	keys := new(discovery.SigningKeys)
+	if err := keys.Fetch(ctx, config.JWKSURI); err != nil {
+		return nil, err
+	}
+
+	v.issuer = config.Issuer
+	v.jwksURI = config.JWKSURI
+	v.keys = keys
+	return v, nil
+}
+
+// checkAlgorithms verifies that algs are asymmetric signature algorithms we support.
+func checkAlgorithms(algs []string) error {
+	if len(algs) == 0 {
+		return errors.New("at least one signature algorithm must be accepted")
+	}
+
+	supported := tokens.SupportedAlgorithms()
+	for _, alg := range algs {
+		found := false
+		for _, s := range supported {
+			if alg == s {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("signature algorithm %q is not supported, expected any of: %s", alg, strings.Join(supported, ", "))
+		}
+	}
+	return nil
+}
+
+// Verify checks the signature and claims of an access token, returning its claims. If signing keys
+// need to be fetched, Verify stops waiting for them once ctx is done.
+func (v *Verifier) Verify(ctx context.Context, token string) (*tokens.JSONWebToken, error) {
+	lookup := func(kid string) (jose.JSONWebKey, error) {
+		return v.key(ctx, kid)
+	}
+
+	if _, err := tokens.VerifyWith(token, v.allowed, lookup); err != nil {
+		return nil, &TokenError{Reason: err.Error()}
+	}
+
+	claims, err := tokens.Decode(token)
+	if err != nil {
+		return nil, &TokenError{Reason: err.Error()}
+	}
+
+	if err := v.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// validate checks the registered claims of an access token.
+func (v *Verifier) validate(claims *tokens.JSONWebToken) error {
+	now := v.now()
+
+	if claims.Issuer != v.issuer {
+		return &TokenError{Reason: fmt.Sprintf("issuer %q is not trusted", claims.Issuer)}
+	}
+
+	if !claims.IntendedFor(v.audience) {
+		return &TokenError{Reason: fmt.Sprintf("token is not intended for %q", v.audience)}
+	}
+
+	if claims.Expires == 0 {
+		return &TokenError{Reason: "token has no expiration"}
+	}
+
+	if now.After(time.Unix(claims.Expires, 0).Add(leeway)) {
+		return &TokenError{Reason: "token expired"}
+	}
+
+	if claims.NotBefore != 0 && now.Add(leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return &TokenError{Reason: "token is not valid yet"}
+	}
+
+	if claims.IssuedAt != 0 && now.Add(leeway).Before(time.Unix(claims.IssuedAt, 0)) {
+		return &TokenError{Reason: "token was issued in the future"}
+	}
+
+	return nil
+}
+
+// key returns the signing key with the given ID. Keys are fetched again once their cache expires,
+// or when the key is unknown, such as after the provider rotated its keys. Fetches are rate limited,
+// and done outside the lock, so that requests signed with known keys are not held up by them.
+func (v *Verifier) key(ctx context.Context, kid string) (jose.JSONWebKey, error) {
+	v.mu.RLock()
+	stale := !v.keys.Fresh(v.jwksURI)
+	key, err := v.keys.Key(kid)
+	fetchedAt := v.keys.FetchedAt
+	v.mu.RUnlock()
+
+	if (err == nil && !stale) || time.Since(fetchedAt) < refetchInterval {
+		return key, err
+	}
+
+	var fetchErr error
+	select {
+	case res := <-v.fetches.DoChan(v.jwksURI, v.fetchKeys):
+		fetchErr = res.Err
+	case <-ctx.Done():
+		fetchErr = ctx.Err()
+	}
+
+	if fetchErr != nil {
+		// Cached keys are still used while the provider is unavailable.
+		if err == nil {
+			return key, nil
+		}
+		return key, fetchErr
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.keys.Key(kid)
+}
+
+// fetchKeys fetches signing keys into a copy of the current ones, replacing them once done. The
+// fetch is shared by all the requests waiting for it, so it is not bound to any of their contexts.
+func (v *Verifier) fetchKeys() (interface{}, error) {
+	v.mu.RLock()
+	keys := v.keys.Copy()
+	v.mu.RUnlock()
+
+	err := keys.Fetch(oauth2.NewContext(context.Background(), v.httpClient), v.jwksURI)
+
+	// Failed fetches are recorded too, so that they are rate limited.
+	v.mu.Lock()
+	v.keys = keys
+	v.mu.Unlock()
+	return nil, err
+}
+
+// Authorize checks that claims were granted all the required scopes, exactly as named. Use a
+// Verifier created WithHierarchicalScopes to also grant scopes below the granted ones.
+func Authorize(claims *tokens.JSONWebToken, required []string) error {
+	return authorize(required, func(scope string) bool {
+		for _, granted := range claims.Scope {
+			if granted == scope {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// authorize checks that claims were granted the required scopes, matching them as configured.
+func (v *Verifier) authorize(claims *tokens.JSONWebToken, required []string) error {
+	if !v.hierarchical {
+		return Authorize(claims, required)
+	}
+	return authorize(required, claims.HasScope)
+}
+
+// authorize returns a *ScopeError listing the required scopes for which granted returns false.
+func authorize(required []string, granted func(scope string) bool) error {
+	var missing []string
+	for _, scope := range required {
+		if !granted(scope) {
+			missing = append(missing, scope)
+		}
+	}
+
+	if len(missing) > 0 {
+		return &ScopeError{Missing: missing}
+	}
+	return nil
+}
+
+// bearerToken extracts the token from an Authorization header value.
+func bearerToken(authorization string) (string, error) {
+	parts := strings.SplitN(authorization, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") || parts[1] == "" {
+		return "", &TokenError{Reason: "no Bearer token found"}
+	}
+	return strings.TrimSpace(parts[1]), nil
+}
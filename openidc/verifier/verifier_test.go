@@ -0,0 +1,455 @@
+package verifier
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	jose "gopkg.in/square/go-jose.v2"
+
+	"github.com/lift-plugins/auth/openidc/tokens"
+)
+
+// testProvider is an identity provider publishing a single signing key at a time.
+type testProvider struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	key          *ecdsa.PrivateKey
+	kid          string
+	jwksRequests int
+	// slow, if set, holds responses with signing keys until it is closed.
+	slow chan struct{}
+}
+
+// provider starts an identity provider publishing a single signing key.
+func provider(t *testing.T) *testProvider {
+	p := new(testProvider)
+	p.rotate(t, "key")
+
+	mux := http.NewServeMux()
+	p.Server = httptest.NewTLSServer(mux)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"ES256"},
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.jwksRequests++
+		slow := p.slow
+		p.mu.Unlock()
+
+		if slow != nil {
+			<-slow
+		}
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &p.key.PublicKey, KeyID: p.kid, Algorithm: "ES256", Use: "sig"},
+		}})
+	})
+	return p
+}
+
+// newKey generates an ES256 signing key.
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	return key
+}
+
+// rotate replaces the signing key published by the provider with a new one.
+func (p *testProvider) rotate(t *testing.T, kid string) {
+	key := newKey(t)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.key = key
+	p.kid = kid
+}
+
+// requests returns how many times signing keys were fetched.
+func (p *testProvider) requests() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.jwksRequests
+}
+
+// sign returns an access token with the given claims, signed with the current provider key.
+func (p *testProvider) sign(t *testing.T, claims *tokens.JSONWebToken) string {
+	p.mu.Lock()
+	key := jose.SigningKey{Algorithm: jose.ES256, Key: jose.JSONWebKey{Key: p.key, KeyID: p.kid}}
+	p.mu.Unlock()
+	return signWith(t, key, claims)
+}
+
+func signWith(t *testing.T, key jose.SigningKey, claims *tokens.JSONWebToken) string {
+	signer, err := jose.NewSigner(key, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	token, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	return token
+}
+
+// verifier returns a Verifier for the provider, accepting tokens for https://api.hooklift.io.
+func (p *testProvider) verifier(t *testing.T, options ...Option) *Verifier {
+	options = append([]Option{WithHTTPClient(p.Client())}, options...)
+	v, err := New(p.URL, "https://api.hooklift.io", options...)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	return v
+}
+
+// valid returns claims of a valid access token issued by the provider, granted the deploy scope.
+func (p *testProvider) valid() *tokens.JSONWebToken {
+	return &tokens.JSONWebToken{
+		Issuer:   p.URL,
+		Subject:  "user",
+		Audience: tokens.Audience{"https://api.hooklift.io"},
+		Expires:  time.Now().Add(time.Hour).Unix(),
+		Scope:    []string{"deploy"},
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	p := provider(t)
+	defer p.Close()
+
+	v := p.verifier(t)
+	handler := v.Middleware("deploy")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := FromContext(r.Context())
+		if !ok || claims.Subject != "user" {
+			t.Errorf("expected claims in request context, got %+v", claims)
+		}
+	}))
+
+	tests := []struct {
+		desc   string
+		modify func(*tokens.JSONWebToken)
+		status int
+	}{
+		{"valid", func(c *tokens.JSONWebToken) {}, http.StatusOK},
+		{"wrong issuer", func(c *tokens.JSONWebToken) { c.Issuer = "https://evil.io" }, http.StatusUnauthorized},
+		{"wrong audience", func(c *tokens.JSONWebToken) { c.Audience = tokens.Audience{"https://git.hooklift.io"} }, http.StatusUnauthorized},
+		{"expired", func(c *tokens.JSONWebToken) { c.Expires = time.Now().Add(-time.Hour).Unix() }, http.StatusUnauthorized},
+		{"missing scope", func(c *tokens.JSONWebToken) { c.Scope = []string{"read"} }, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		claims := p.valid()
+		tt.modify(claims)
+
+		req := httptest.NewRequest(http.MethodGet, "/apps", nil)
+		req.Header.Set("Authorization", "Bearer "+p.sign(t, claims))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.desc, tt.status, rec.Code, rec.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/apps", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("missing token: expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	p := provider(t)
+	defer p.Close()
+
+	for _, alg := range []string{"HS256", "none"} {
+		if _, err := New(p.URL, "https://api.hooklift.io", WithHTTPClient(p.Client()), WithAlgorithms(alg)); err == nil {
+			t.Errorf("expected %s to be rejected", alg)
+		}
+	}
+
+	// A token signed with HMAC, using the public key as the secret, must not be accepted.
+	v := p.verifier(t)
+	p.mu.Lock()
+	secret, err := json.Marshal(jose.JSONWebKey{Key: &p.key.PublicKey})
+	p.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged := signWith(t, jose.SigningKey{Algorithm: jose.HS256, Key: jose.JSONWebKey{Key: secret, KeyID: "key"}}, p.valid())
+	if _, err := v.Verify(context.Background(), forged); err == nil {
+		t.Error("expected HS256 tokens to be rejected")
+	}
+
+	v = p.verifier(t, WithAlgorithms("RS256"))
+	if _, err := v.Verify(context.Background(), p.sign(t, p.valid())); err == nil {
+		t.Error("expected ES256 tokens to be rejected when only RS256 is allowed")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	p := provider(t)
+	defer p.Close()
+
+	v := p.verifier(t)
+	old := p.sign(t, p.valid())
+
+	p.rotate(t, "rotated")
+	rotated := p.sign(t, p.valid())
+
+	// Keys were just fetched, so unknown keys are not fetched again right away. Otherwise, tokens with
+	// forged key IDs would make us flood the provider with requests.
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(context.Background(), rotated); err == nil {
+			t.Error("expected token signed with an unknown key to be rejected")
+		}
+	}
+
+	if requests := p.requests(); requests != 1 {
+		t.Errorf("expected keys to be fetched once, got %d fetches", requests)
+	}
+
+	v.mu.Lock()
+	v.keys.FetchedAt = time.Now().Add(-refetchInterval)
+	v.mu.Unlock()
+
+	if _, err := v.Verify(context.Background(), rotated); err != nil {
+		t.Errorf("expected keys to be fetched again for an unknown key: %v", err)
+	}
+
+	if requests := p.requests(); requests != 2 {
+		t.Errorf("expected keys to be fetched again, got %d fetches", requests)
+	}
+
+	if _, err := v.Verify(context.Background(), old); err != nil {
+		t.Errorf("expected tokens signed with the retired key to be accepted during its grace period: %v", err)
+	}
+}
+
+func TestSlowKeyFetch(t *testing.T) {
+	p := provider(t)
+	defer p.Close()
+
+	v := p.verifier(t)
+	known := p.sign(t, p.valid())
+
+	unknown := signWith(t, jose.SigningKey{Algorithm: jose.ES256, Key: jose.JSONWebKey{Key: newKey(t), KeyID: "unknown"}}, p.valid())
+
+	slow := make(chan struct{})
+	p.mu.Lock()
+	p.slow = slow
+	p.mu.Unlock()
+
+	v.mu.Lock()
+	v.keys.FetchedAt = time.Now().Add(-refetchInterval)
+	v.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := v.Verify(context.Background(), unknown); err == nil {
+				t.Error("expected token signed with an unknown key to be rejected")
+			}
+		}()
+	}
+
+	for p.requests() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Tokens signed with known keys are verified while unknown keys are being fetched.
+	verified := make(chan error)
+	go func() {
+		_, err := v.Verify(context.Background(), known)
+		verified <- err
+	}()
+
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Errorf("unexpected error: %+v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("verifying a token signed with a known key waited for keys being fetched")
+	}
+
+	// Requests waiting for keys stop once their context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := v.Verify(ctx, unknown); err == nil {
+		t.Error("expected token signed with an unknown key to be rejected")
+	}
+
+	close(slow)
+	wg.Wait()
+
+	if requests := p.requests(); requests != 2 {
+		t.Errorf("expected concurrent fetches to be deduplicated, got %d fetches", requests)
+	}
+}
+
+// authorized returns a context with the incoming metadata of a call authenticated with token.
+func authorized(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	p := provider(t)
+	defer p.Close()
+
+	interceptor := p.verifier(t).UnaryServerInterceptor(map[string][]string{
+		"/hooklift.apps.Apps/Deploy": {"deploy"},
+		"/hooklift.apps.Apps/Delete": {"delete"},
+	})
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		claims, ok := FromContext(ctx)
+		if !ok || claims.Subject != "user" {
+			t.Errorf("expected claims in call context, got %+v", claims)
+		}
+		return "reply", nil
+	}
+
+	expired := p.valid()
+	expired.Expires = time.Now().Add(-time.Hour).Unix()
+
+	tests := []struct {
+		desc   string
+		ctx    context.Context
+		method string
+		code   codes.Code
+	}{
+		{"granted scope", authorized(p.sign(t, p.valid())), "/hooklift.apps.Apps/Deploy", codes.OK},
+		{"method without scopes", authorized(p.sign(t, p.valid())), "/hooklift.apps.Apps/List", codes.OK},
+		{"missing scope", authorized(p.sign(t, p.valid())), "/hooklift.apps.Apps/Delete", codes.PermissionDenied},
+		{"expired token", authorized(p.sign(t, expired)), "/hooklift.apps.Apps/List", codes.Unauthenticated},
+		{"no token", context.Background(), "/hooklift.apps.Apps/List", codes.Unauthenticated},
+	}
+
+	for _, tt := range tests {
+		reply, err := interceptor(tt.ctx, "request", &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+		if code := grpc.Code(err); code != tt.code {
+			t.Errorf("%s: expected code %s, got %v", tt.desc, tt.code, err)
+		}
+
+		if tt.code == codes.OK && reply != "reply" {
+			t.Errorf("%s: expected handler reply, got %v", tt.desc, reply)
+		}
+	}
+}
+
+// fakeServerStream is a server stream of a call made with ctx.
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	p := provider(t)
+	defer p.Close()
+
+	interceptor := p.verifier(t).StreamServerInterceptor(map[string][]string{
+		"/hooklift.apps.Apps/Logs": {"logs"},
+	})
+
+	var handled bool
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		handled = true
+		if claims, ok := FromContext(ss.Context()); !ok || claims.Subject != "user" {
+			t.Errorf("expected claims in stream context, got %+v", claims)
+		}
+		return nil
+	}
+
+	info := &grpc.StreamServerInfo{FullMethod: "/hooklift.apps.Apps/Logs", IsServerStream: true}
+	err := interceptor(nil, &fakeServerStream{ctx: authorized(p.sign(t, p.valid()))}, info, handler)
+	if grpc.Code(err) != codes.PermissionDenied || handled {
+		t.Errorf("expected the stream to be denied without the logs scope, got %v", err)
+	}
+
+	claims := p.valid()
+	claims.Scope = []string{"logs"}
+	if err := interceptor(nil, &fakeServerStream{ctx: authorized(p.sign(t, claims))}, info, handler); err != nil || !handled {
+		t.Errorf("expected the stream to be handled, got %v", err)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	claims := &tokens.JSONWebToken{Scope: []string{"apps", "logs:*", "deploy"}}
+
+	tests := []struct {
+		required     []string
+		exact        bool
+		hierarchical bool
+	}{
+		{[]string{"deploy"}, true, true},
+		{[]string{"apps", "deploy"}, true, true},
+		{[]string{"apps:deploy"}, false, true},
+		{[]string{"logs:web"}, false, true},
+		{[]string{"logs"}, false, false},
+		{[]string{"admin"}, false, false},
+	}
+
+	p := provider(t)
+	defer p.Close()
+	v := p.verifier(t, WithHierarchicalScopes())
+
+	for _, tt := range tests {
+		if err := Authorize(claims, tt.required); (err == nil) != tt.exact {
+			t.Errorf("%v: expected exact matching to grant it: %t, got %v", tt.required, tt.exact, err)
+		}
+
+		if err := v.authorize(claims, tt.required); (err == nil) != tt.hierarchical {
+			t.Errorf("%v: expected hierarchical matching to grant it: %t, got %v", tt.required, tt.hierarchical, err)
+		}
+	}
+
+	err := Authorize(claims, []string{"apps:deploy", "deploy", "admin"})
+	if serr, ok := err.(*ScopeError); !ok || len(serr.Missing) != 2 || serr.Missing[0] != "apps:deploy" || serr.Missing[1] != "admin" {
+		t.Errorf("expected missing scopes to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"github.com/lift-plugins/auth/openidc/profiles"
+	"github.com/lift-plugins/auth/openidc/store"
+	"github.com/pkg/errors"
+)
+
+// SelectProfile selects the profile used by this process. Each profile keeps its own
+// tokens, client and provider configuration, allowing to be signed in to several
+// identity providers, or with several accounts, at the same time.
+func SelectProfile(name string) error {
+	return profiles.Select(name)
+}
+
+// Profiles returns the names of the stored profiles along with the name of the active one.
+func Profiles() ([]string, string, error) {
+	names, err := profiles.List()
+	if err != nil {
+		return nil, "", err
+	}
+	return names, profiles.Current(), nil
+}
+
+// UseProfile sets the profile to use when none is selected through --profile or
+// LIFT_AUTH_PROFILE.
+func UseProfile(name string) error {
+	return profiles.Use(name)
+}
+
+// DeleteProfile removes all data stored locally for the given profile, including secrets kept
+// in the keyring. Tokens are not revoked, use SignOut for that.
+func DeleteProfile(name string) error {
+	return deleteProfile(name, store.New(name))
+}
+
+// deleteProfile deletes every document of the profile through s, so that secrets stored
+// outside the profile directory are removed too, and then removes the profile directory.
+func deleteProfile(name string, s store.Store) error {
+	files, err := profiles.Files(name)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := s.Delete(file); err != nil {
+			return errors.Wrapf(err, "failed deleting %q of profile %q", file, name)
+		}
+	}
+	return profiles.Delete(name)
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/lift-plugins/auth/openidc/profiles"
+	"github.com/lift-plugins/auth/openidc/store"
+)
+
+// deletingStore records the documents deleted through it.
+type deletingStore struct {
+	store.Store
+	deleted []string
+}
+
+func (s *deletingStore) Delete(name string) error {
+	s.deleted = append(s.deleted, name)
+	return s.Store.Delete(name)
+}
+
+func TestDeleteProfileDeletesDocumentsThroughStore(t *testing.T) {
+	const name = "delete-test"
+	s := &deletingStore{Store: &store.File{Profile: name}}
+	for _, doc := range []string{"client.json", "tokens.json"} {
+		if err := s.Write(doc, map[string]string{"secret": "kept in a keyring"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := deleteProfile(name, s); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(s.deleted, []string{"client.json", "tokens.json"}) {
+		t.Errorf("expected every document to be deleted through the store, got %v", s.deleted)
+	}
+
+	if _, err := os.Stat(profiles.Dir(name)); !os.IsNotExist(err) {
+		t.Errorf("expected profile directory to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// testProvider is a fake OpenID provider serving its discovery document and signing keys. Tests
+// register handlers for the other endpoints on mux.
+type testProvider struct {
+	*httptest.Server
+	mux   *http.ServeMux
+	key   *ecdsa.PrivateKey
+	store *memStore
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &testProvider{
+		mux:   http.NewServeMux(),
+		key:   key,
+		store: newMemStore(),
+	}
+	p.Server = httptest.NewTLSServer(p.mux)
+
+	p.mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"userinfo_endpoint":                     p.URL + "/userinfo",
+			"revocation_endpoint":                   p.URL + "/revoke",
+			"introspection_endpoint":                p.URL + "/introspect",
+			"end_session_endpoint":                  p.URL + "/logout",
+			"jwks_uri":                              p.URL + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"ES256"},
+		})
+	})
+
+	p.mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: "ES256", Use: "sig"},
+		}})
+	})
+	return p
+}
+
+// client returns a Client for the provider, persisting data in p.store.
+func (p *testProvider) client(options ...Option) *Client {
+	options = append([]Option{WithProvider(p.URL), WithHTTPClient(p.Client()), WithStore(p.store)}, options...)
+	return NewClient(options...)
+}
+
+// sign returns a JWT with the given claims, signed with the provider key.
+func (p *testProvider) sign(t *testing.T, claims interface{}) string {
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.ES256,
+		Key:       jose.JSONWebKey{Key: p.key, KeyID: "test"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hooklift/lift/ui"
+	"github.com/lift-plugins/auth/openidc/discovery"
+	"github.com/lift-plugins/auth/openidc/tokens"
+)
+
+var (
+	// defaultScopes are the scopes requested when none are given, as long as the provider supports them.
+	defaultScopes = []string{"openid", "name", "email", "offline_access"}
+
+	// oidcScopes are the scopes defined by OpenID Connect, along with name, which only ask for ID token
+	// claims or refresh tokens. http://openid.net/specs/openid-connect-core-1_0.html#ScopeClaims
+	oidcScopes = []string{"openid", "profile", "email", "address", "phone", "offline_access", "name"}
+
+	// defaultAudiences are the Hooklift services the access token is intended for, when none are given.
+	defaultAudiences = []string{
+		// To be able to publish and unpublish Lift plugins from Lift registry.
+		"https://lift.hooklift.io",
+		// To be able to interact with Hooklift's Platform API to deploy apps,
+		// tail logs, manage apps configurations, etc.
+		"https://api.hooklift.io",
+		// To be able to interactively deploy using Lift CLI
+		"https://git.hooklift.io",
+	}
+)
+
+// requestedScopes returns the scopes to request. If none were given, the default scopes advertised
+// in the provider's scopes_supported are used. The openid scope is always requested.
+func requestedScopes(scope []string, config *discovery.ProviderConfig) []string {
+	if len(scope) == 0 {
+		for _, s := range defaultScopes {
+			if len(config.Scopes) == 0 || contains(config.Scopes, s) {
+				scope = append(scope, s)
+			}
+		}
+	}
+
+	if !contains(scope, "openid") {
+		scope = append([]string{"openid"}, scope...)
+	}
+	return scope
+}
+
+// requestedAudiences returns the audiences to request, which are the default ones if none were given.
+func requestedAudiences(audience []string) []string {
+	if len(audience) == 0 {
+		return defaultAudiences
+	}
+	return audience
+}
+
+// warnGrantedScopes is the default for WithGrantedScopesHook, displaying a warning.
+func warnGrantedScopes(requested, granted []string) {
+	ui.Info("Warning: requested scopes %q, but were granted %q\n", strings.Join(requested, " "), strings.Join(granted, " "))
+}
+
+// checkGrantedScopes compares the scopes of the access token with the requested ones, calling
+// the hook set with WithGrantedScopesHook if they differ. OpenID Connect scopes are left out of the comparison, since they
+// ask for claims or refresh tokens and providers do not necessarily list them in access tokens.
+// Opaque access tokens, or ones without a scope claim, are not checked.
+func (c *Client) checkGrantedScopes(requested []string, accessToken string) {
+	token, err := tokens.Decode(accessToken)
+	if err != nil {
+		ui.Debug("%+v", err)
+		return
+	}
+
+	if len(token.Scope) == 0 {
+		return
+	}
+
+	if !sameScopes(withoutOIDCScopes(requested), withoutOIDCScopes(token.Scope)) {
+		hook := c.grantedScopesHook
+		if hook == nil {
+			hook = warnGrantedScopes
+		}
+		hook(requested, token.Scope)
+	}
+}
+
+// withoutOIDCScopes returns scope without OpenID Connect scopes.
+func withoutOIDCScopes(scope []string) []string {
+	var filtered []string
+	for _, s := range scope {
+		if !contains(oidcScopes, s) {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered
+}
+
+// sameScopes returns whether a and b hold the same scopes, regardless of order.
+func sameScopes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
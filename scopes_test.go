@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/lift-plugins/auth/openidc/discovery"
+)
+
+func TestRequestedScopes(t *testing.T) {
+	tests := []struct {
+		desc      string
+		scope     []string
+		supported []string
+		expected  []string
+	}{
+		{"defaults", nil, nil, defaultScopes},
+		{"defaults supported by the provider", nil, []string{"openid", "email", "profile"}, []string{"openid", "email"}},
+		{"given scopes", []string{"openid", "deploy"}, []string{"openid"}, []string{"openid", "deploy"}},
+		{"openid always requested", []string{"deploy", "logs"}, nil, []string{"openid", "deploy", "logs"}},
+	}
+
+	for _, tt := range tests {
+		config := &discovery.ProviderConfig{Scopes: tt.supported}
+		if scope := requestedScopes(tt.scope, config); !reflect.DeepEqual(scope, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.desc, tt.expected, scope)
+		}
+	}
+}
+
+func TestCheckGrantedScopes(t *testing.T) {
+	token := func(scope ...string) string {
+		payload, err := json.Marshal(map[string]interface{}{"scope": scope})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "e30." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+	}
+
+	tests := []struct {
+		desc      string
+		requested []string
+		token     string
+		warned    bool
+	}{
+		{"same scopes", []string{"openid", "deploy"}, token("openid", "deploy"), false},
+		{"OpenID Connect scopes left out of the token", []string{"openid", "email", "offline_access", "deploy"}, token("deploy"), false},
+		{"scope not granted", []string{"openid", "deploy", "logs"}, token("deploy"), true},
+		{"extra scope granted", []string{"openid", "deploy"}, token("deploy", "admin"), true},
+		{"no scope claim", []string{"openid", "deploy"}, token(), false},
+		{"opaque token", []string{"openid", "deploy"}, "opaque", false},
+	}
+
+	for _, tt := range tests {
+		warned := false
+		c := NewClient(WithGrantedScopesHook(func(requested, granted []string) { warned = true }))
+
+		c.checkGrantedScopes(tt.requested, tt.token)
+		if warned != tt.warned {
+			t.Errorf("%s: expected warning to be %t", tt.desc, tt.warned)
+		}
+	}
+}
@@ -1,11 +1,11 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
 	"fmt"
 
 	"github.com/pkg/errors"
-	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 
@@ -17,15 +17,38 @@ import (
 	"github.com/lift-plugins/auth/openidc/tokens"
 )
 
-// SignIn authenticates the user against an identity provider.
-func SignIn(email, password, address string) error {
-	ctx := context.Background()
+// SignIn authenticates the user against an identity provider. Scopes and audiences default to
+// the ones supported by the provider and Hooklift services, respectively, if none are given.
+func SignIn(email, password, address string, scope, audience []string) error {
+	c := NewClient(WithProvider(address), WithScopes(scope...), WithAudiences(audience...))
+	return c.SignIn(context.Background(), email, password)
+}
+
+// SignIn authenticates the user with email and password.
+func (c *Client) SignIn(ctx context.Context, email, password string) error {
+	ctx, err := c.bind(ctx)
+	if err != nil {
+		return err
+	}
 
-	client, err := openidc.RegisterClient(ctx, address, email, password)
+	address := c.providerAddress()
+	client, err := openidc.RegisterClient(ctx, c.store, address, email, password)
 	if err != nil {
 		return err
 	}
 
+	// Discovers OpenID Connect configuration for the given provider address and refreshes cached
+	// configuration and signing keys.
+	if err := discovery.Run(ctx, c.store, address); err != nil {
+		return errors.Wrapf(err, "failed discovering identity config from %q", address)
+	}
+
+	config := new(discovery.ProviderConfig)
+	if err := config.Read(c.store); err != nil {
+		return err
+	}
+	scope := requestedScopes(c.scopes, config)
+
 	csrfToken, err := randomValue()
 	if err != nil {
 		return errors.Wrap(err, "failed getting random value for CSRF token")
@@ -39,22 +62,14 @@ func SignIn(email, password, address string) error {
 	req := &api.SignInRequest{
 		Username:     email,
 		Password:     password,
-		Scope:        []string{"openid", "name", "email", "offline_access", "admin"},
+		Scope:        scope,
 		ResponseType: []string{"token", "id_token"},
-		Audience: []string{
-			// To be able to publish and unpublish Lift plugins from Lift registry.
-			"https://lift.hooklift.io",
-			// To be able to interact with Hooklift's Platform API to deploy apps,
-			// tail logs, manage apps configurations, etc.
-			"https://api.hooklift.io",
-			// To be able to interactively deploy using Lift CLI
-			"https://git.hooklift.io",
-		},
-		State: csrfToken,
-		Nonce: nonce,
+		Audience:     requestedAudiences(c.audiences),
+		State:        csrfToken,
+		Nonce:        nonce,
 	}
 
-	grpcConn, err := grpcutil.Connection(address, "lift-auth", client.ClientId, client.ClientSecret)
+	grpcConn, err := grpcutil.Dial(ctx, c.store, address, "lift-auth", grpcutil.WithBasicAuth(client.ClientId, client.ClientSecret))
 	if err != nil {
 		return errors.Wrap(err, "failed connecting to openid provider.")
 	}
@@ -76,14 +91,8 @@ func SignIn(email, password, address string) error {
 		return errors.New("CSRF token received does not match value sent")
 	}
 
-	// Discovers OpenID Connect configuration for the given provider address and refreshes cached
-	// configuration and signing keys.
-	if err := discovery.Run(address); err != nil {
-		return errors.Wrapf(err, "failed discovering identity config from %q", address)
-	}
-
 	tokens := &tokens.Tokens{
-		Issuer:  address,
+		Issuer:  config.Issuer,
 		ID:      resp.IdToken,
 		Access:  resp.AccessToken,
 		Refresh: resp.RefreshToken,
@@ -91,11 +100,12 @@ func SignIn(email, password, address string) error {
 
 	// Verifies that ID token hasn't been tampared by checking its signature and relationship
 	// with the Access token.
-	if err := tokens.Verify(client.ClientId, nonce); err != nil {
+	if err := tokens.Verify(ctx, c.store, client.ClientId, nonce, c.verifyOptions(false)...); err != nil {
 		return errors.Wrap(err, "failed validating received tokens")
 	}
+	c.checkGrantedScopes(scope, tokens.Access)
 
-	return tokens.Write()
+	return tokens.WriteSession(c.store)
 }
 
 // randomValue returns a cryptographically random value.
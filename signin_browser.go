@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/lift-plugins/auth/openidc"
+	"github.com/lift-plugins/auth/openidc/discovery"
+	"github.com/lift-plugins/auth/openidc/loopback"
+	"github.com/lift-plugins/auth/openidc/oauth2"
+	"github.com/lift-plugins/auth/openidc/tokens"
+)
+
+// browserTimeout is how long we wait for the user to complete signing in through the browser.
+const browserTimeout = 5 * time.Minute
+
+// SignInWithBrowser authenticates the user using the Authorization Code flow with PKCE, as
+// recommended for native apps in https://tools.ietf.org/html/rfc8252. The authorization response
+// is received by a short-lived HTTP server listening on the registered loopback redirect URI.
+// open is called with the authorization URL, it is expected to open it in the user's browser.
+// Scopes and audiences take defaults if none are given, as in SignIn.
+func SignInWithBrowser(address string, scope, audience []string, open func(authzURL string) error) error {
+	c := NewClient(WithProvider(address), WithScopes(scope...), WithAudiences(audience...))
+	return c.SignInWithBrowser(context.Background(), open)
+}
+
+// SignInWithBrowser authenticates the user through the web browser. See SignInWithBrowser function
+// for details.
+func (c *Client) SignInWithBrowser(ctx context.Context, open func(authzURL string) error) error {
+	ctx, err := c.bind(ctx)
+	if err != nil {
+		return err
+	}
+
+	address := c.providerAddress()
+	client, err := openidc.RegisterClient(ctx, c.store, address, "", "")
+	if err != nil {
+		return err
+	}
+
+	// Discovers OpenID Connect configuration for the given provider address and refreshes cached
+	// configuration and signing keys.
+	if err := discovery.Run(ctx, c.store, address); err != nil {
+		return errors.Wrapf(err, "failed discovering identity config from %q", address)
+	}
+
+	config := new(discovery.ProviderConfig)
+	if err := config.Read(c.store); err != nil {
+		return err
+	}
+
+	if config.AuthzEndpoint == "" {
+		return fmt.Errorf("%q does not advertise an authorization endpoint", address)
+	}
+
+	scope := requestedScopes(c.scopes, config)
+
+	csrfToken, err := randomValue()
+	if err != nil {
+		return errors.Wrap(err, "failed getting random value for CSRF token")
+	}
+
+	nonce, err := randomValue()
+	if err != nil {
+		return errors.Wrap(err, "failed getting random value for ID Token nonce")
+	}
+
+	pkce, err := oauth2.NewPKCE()
+	if err != nil {
+		return err
+	}
+
+	server, err := loopback.Listen(openidc.RedirectURI, csrfToken)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+
+	authzURL, err := url.Parse(config.AuthzEndpoint)
+	if err != nil {
+		return errors.Wrapf(err, "failed parsing authorization endpoint %q", config.AuthzEndpoint)
+	}
+
+	query := authzURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", client.ClientId)
+	query.Set("redirect_uri", openidc.RedirectURI)
+	query.Set("scope", strings.Join(scope, " "))
+	query.Set("state", csrfToken)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", pkce.Challenge)
+	query.Set("code_challenge_method", pkce.ChallengeMethod)
+	for _, aud := range requestedAudiences(c.audiences) {
+		query.Add("audience", aud)
+	}
+
+	if c.maxAge > 0 {
+		query.Set("max_age", strconv.FormatInt(int64(c.maxAge/time.Second), 10))
+	}
+	authzURL.RawQuery = query.Encode()
+
+	if err := open(authzURL.String()); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, browserTimeout)
+	defer cancel()
+
+	params, err := server.Wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	if params.Get("state") != csrfToken {
+		return errors.New("CSRF token received does not match value sent")
+	}
+
+	if errCode := params.Get("error"); errCode != "" {
+		return &tokens.ProviderError{
+			Code:        errCode,
+			Description: params.Get("error_description"),
+			URI:         params.Get("error_uri"),
+		}
+	}
+
+	code := params.Get("code")
+	if code == "" {
+		return errors.New("no authorization code was received")
+	}
+
+	tks, err := tokens.ExchangeCode(ctx, config.TokenEndpoint, client.ClientId, client.ClientSecret, code, openidc.RedirectURI, pkce.Verifier)
+	if err != nil {
+		return err
+	}
+	tks.Issuer = config.Issuer
+
+	// Verifies that ID token hasn't been tampared by checking its signature and relationship
+	// with the Access token and the authorization code.
+	if err := tks.Verify(ctx, c.store, client.ClientId, nonce, c.verifyOptions(true)...); err != nil {
+		return errors.Wrap(err, "failed validating received tokens")
+	}
+
+	if err := tks.VerifyCode(ctx, c.store, code); err != nil {
+		return errors.Wrap(err, "failed validating received tokens")
+	}
+	c.checkGrantedScopes(scope, tks.Access)
+
+	return tks.WriteSession(c.store)
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/pkg/errors"
+
+	"github.com/lift-plugins/auth/openidc/clients"
+	"github.com/lift-plugins/auth/openidc/discovery"
+	"github.com/lift-plugins/auth/openidc/tokens"
+)
+
+// SignInWithClientCredentials authenticates a service identity, such as a CI pipeline, using the
+// OAuth 2.0 Client Credentials Grant. It requires no user interaction. The client credentials are
+// stored apart from the OpenIDC client registered for users, so that tokens can be requested again
+// once they expire without replacing that client. If no scopes
+// are given, the provider grants the ones configured for the client. Audiences default as in SignIn.
+func SignInWithClientCredentials(clientID, clientSecret, address string, scope, audience []string) error {
+	c := NewClient(WithProvider(address), WithScopes(scope...), WithAudiences(audience...))
+	return c.SignInWithClientCredentials(context.Background(), clientID, clientSecret)
+}
+
+// SignInWithClientCredentials authenticates a service identity. See SignInWithClientCredentials
+// function for details.
+func (c *Client) SignInWithClientCredentials(ctx context.Context, clientID, clientSecret string) error {
+	ctx, err := c.bind(ctx)
+	if err != nil {
+		return err
+	}
+
+	address := c.providerAddress()
+	scope := c.scopes
+	if clientID == "" || clientSecret == "" {
+		return errors.New("client ID and client secret are required")
+	}
+
+	// Discovers OpenID Connect configuration for the given provider address and refreshes cached
+	// configuration and signing keys.
+	if err := discovery.Run(ctx, c.store, address); err != nil {
+		return errors.Wrapf(err, "failed discovering identity config from %q", address)
+	}
+
+	config := new(discovery.ProviderConfig)
+	if err := config.Read(c.store); err != nil {
+		return err
+	}
+
+	tks, err := tokens.ClientCredentials(ctx, config.TokenEndpoint, clientID, clientSecret, scope, requestedAudiences(c.audiences))
+	if err != nil {
+		return err
+	}
+
+	if len(scope) > 0 {
+		c.checkGrantedScopes(scope, tks.Access)
+	}
+	tks.Issuer = config.Issuer
+
+	client := new(clients.Client)
+	client.ClientId = clientID
+	client.ClientSecret = clientSecret
+	client.ClientIdIssuedAt = ptypes.TimestampNow()
+	if err := client.WriteService(c.store); err != nil {
+		return err
+	}
+
+	return tks.WriteSession(c.store)
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/lift-plugins/auth/openidc/clients"
+	"github.com/lift-plugins/auth/openidc/tokens"
+)
+
+func TestSignInWithClientCredentialsKeepsUserClient(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+
+	p.mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if user, _, _ := r.BasicAuth(); user != "ci" {
+			t.Errorf("expected service credentials, got %q", user)
+		}
+		fmt.Fprint(w, `{"access_token": "service", "token_type": "Bearer", "expires_in": 3600}`)
+	})
+
+	userClient := new(clients.Client)
+	userClient.ClientId = "lift-cli"
+	userClient.ClientSecret = "user-secret"
+	if err := userClient.Write(p.store); err != nil {
+		t.Fatal(err)
+	}
+
+	// The provider address is spelled differently from the issuer it discovers.
+	c := p.client(WithProvider(strings.TrimPrefix(p.URL, "https://") + "/"))
+	if err := c.SignInWithClientCredentials(context.Background(), "ci", "ci-secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := new(clients.Client)
+	if err := stored.Read(p.store); err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.ClientId != "lift-cli" || stored.ClientSecret != "user-secret" {
+		t.Errorf("client registered for users was replaced: %+v", stored.RegisterApp)
+	}
+
+	_, client, err := c.session()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if client.ClientId != "ci" || client.ClientSecret != "ci-secret" {
+		t.Errorf("expected service credentials for the service session, got %+v", client.RegisterApp)
+	}
+
+	tks := new(tokens.Tokens)
+	if err := tks.Read(p.store); err != nil {
+		t.Fatal(err)
+	}
+
+	if !tks.ServiceIdentity || tks.Access != "service" {
+		t.Errorf("expected service identity tokens, got %+v", tks)
+	}
+
+	if tks.Issuer != p.URL {
+		t.Errorf("expected tokens to record the discovered issuer %q, got %q", p.URL, tks.Issuer)
+	}
+}
+
+func TestSignInDiscardsExchangedTokens(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+
+	p.mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		access, _, _ := r.BasicAuth()
+		if r.FormValue("grant_type") != "client_credentials" {
+			access = "exchanged " + r.FormValue("subject_token")
+		}
+		fmt.Fprintf(w, `{"access_token": %q, "token_type": "Bearer", "expires_in": 3600}`, access)
+	})
+
+	c := p.client()
+	for _, account := range []string{"alice", "bob"} {
+		if err := c.SignInWithClientCredentials(context.Background(), account, "secret"); err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := c.ExchangeToken(context.Background(), "api", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected := "exchanged " + account; token != expected {
+			t.Errorf("expected %q after signing in as %s, got %q", expected, account, token)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/lift-plugins/auth/openidc"
+	"github.com/lift-plugins/auth/openidc/discovery"
+	"github.com/lift-plugins/auth/openidc/tokens"
+)
+
+// SignInWithDevice authenticates the user using the OAuth 2.0 Device Authorization Grant, as
+// specified in https://tools.ietf.org/html/rfc8628. It allows signing in from hosts without
+// a browser, or where SSO or MFA is enforced. prompt is called with the code the user
+// has to enter at the verification URI, it is expected to display them to the user. Scopes and
+// audiences take defaults if none are given, as in SignIn.
+func SignInWithDevice(address string, scope, audience []string, prompt func(userCode, verificationURI string)) error {
+	c := NewClient(WithProvider(address), WithScopes(scope...), WithAudiences(audience...))
+	return c.SignInWithDevice(context.Background(), prompt)
+}
+
+// SignInWithDevice authenticates the user using the device authorization grant. See SignInWithDevice
+// function for details.
+func (c *Client) SignInWithDevice(ctx context.Context, prompt func(userCode, verificationURI string)) error {
+	ctx, err := c.bind(ctx)
+	if err != nil {
+		return err
+	}
+
+	address := c.providerAddress()
+	client, err := openidc.RegisterClient(ctx, c.store, address, "", "")
+	if err != nil {
+		return err
+	}
+
+	// Discovers OpenID Connect configuration for the given provider address and refreshes cached
+	// configuration and signing keys.
+	if err := discovery.Run(ctx, c.store, address); err != nil {
+		return errors.Wrapf(err, "failed discovering identity config from %q", address)
+	}
+
+	config := new(discovery.ProviderConfig)
+	if err := config.Read(c.store); err != nil {
+		return err
+	}
+
+	scope := requestedScopes(c.scopes, config)
+	code, err := tokens.RequestDeviceCode(ctx, config.DeviceAuthzEndpoint, client.ClientId, client.ClientSecret, scope, requestedAudiences(c.audiences))
+	if err != nil {
+		return errors.Wrap(err, "failed starting device authorization")
+	}
+
+	verificationURI := code.VerificationURI
+	if code.VerificationURIComplete != "" {
+		verificationURI = code.VerificationURIComplete
+	}
+	prompt(code.UserCode, verificationURI)
+
+	tks, err := code.Poll(ctx, config.TokenEndpoint, client.ClientId, client.ClientSecret)
+	if err != nil {
+		return err
+	}
+	tks.Issuer = config.Issuer
+
+	// The device flow does not support sending a nonce, so the ID token must not have one.
+	if err := tks.Verify(ctx, c.store, client.ClientId, "", c.verifyOptions(false)...); err != nil {
+		return errors.Wrap(err, "failed validating received tokens")
+	}
+	c.checkGrantedScopes(scope, tks.Access)
+
+	return tks.WriteSession(c.store)
+}
@@ -2,49 +2,239 @@ package auth
 
 import (
 	"context"
+	"fmt"
+	"net/url"
 
 	api "github.com/hooklift/apis/go/identity"
 	"github.com/hooklift/lift/ui"
+	"github.com/lift-plugins/auth/openidc"
 	"github.com/lift-plugins/auth/openidc/clients"
+	"github.com/lift-plugins/auth/openidc/discovery"
 	"github.com/lift-plugins/auth/openidc/grpcutil"
+	"github.com/lift-plugins/auth/openidc/loopback"
+	"github.com/lift-plugins/auth/openidc/store"
 	"github.com/lift-plugins/auth/openidc/tokens"
 	"github.com/pkg/errors"
 )
 
+// TokenRevocation is the result of revoking a token with the OpenID Provider when signing out.
+type TokenRevocation struct {
+	// TokenType is either "refresh_token" or "access_token".
+	TokenType string
+	// Audience is the audience of exchanged tokens, empty for session tokens.
+	Audience string
+	// Err is the reason the token could not be revoked, if any.
+	Err error
+}
+
 // SignOut removes locally stored tokens and does best effort to revoke tokens from
 // the OpenID Provider. Any error attempting to sign out from the identity server is silently ignored but
-// can be seen if running plugin with DEBUG enabled.
+// can be seen if running plugin with DEBUG enabled. Credentials of service identities are removed too.
 func SignOut() error {
-	defer tokens.Delete()
+	_, err := SignOutWithResults(false)
+	return err
+}
+
+// SignOutWithResults is like SignOut, but returns the result of revoking each token. If strict is true
+// and any token could not be revoked, an error is returned and tokens are kept locally so that signing
+// out can be retried.
+func SignOutWithResults(strict bool) ([]TokenRevocation, error) {
+	return NewClient().SignOut(context.Background(), strict)
+}
+
+// SignOutGlobally is like SignOutWithResults, but also ends the user's browser session with the
+// OpenID Provider, using RP-initiated logout. open is called with the logout URL, it is expected
+// to open it in the user's browser. The browser session is only ended once tokens were revoked, or
+// regardless of revocation errors if strict is false. Tokens are only removed once the provider
+// redirects the browser back to us, confirming the session ended.
+// http://openid.net/specs/openid-connect-rpinitiated-1_0.html
+func SignOutGlobally(strict bool, open func(logoutURL string) error) ([]TokenRevocation, error) {
+	return NewClient().SignOutGlobally(context.Background(), strict, open)
+}
+
+// SignOut revokes tokens and removes them from the store. See SignOutWithResults function for details.
+func (c *Client) SignOut(ctx context.Context, strict bool) ([]TokenRevocation, error) {
+	return c.signOut(ctx, strict, nil)
+}
+
+// SignOutGlobally also ends the user's browser session with the provider. See SignOutGlobally
+// function for details.
+func (c *Client) SignOutGlobally(ctx context.Context, strict bool, open func(logoutURL string) error) ([]TokenRevocation, error) {
+	return c.signOut(ctx, strict, open)
+}
+
+// signOut revokes tokens, ends the session with the provider and removes tokens from the store. If
+// open is not nil, the browser session with the provider is ended too.
+func (c *Client) signOut(ctx context.Context, strict bool, open func(logoutURL string) error) ([]TokenRevocation, error) {
+	ctx, err := c.bind(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	tks := new(tokens.Tokens)
-	if err := tks.Read(); err != nil {
+	if err := tks.Read(c.store); err != nil {
 		ui.Debug("%+v", errors.Wrap(err, "we were unable to revoke tokens in the server"))
-		return nil
+		tokens.Delete(c.store)
+		return nil, nil
 	}
 
-	client := new(clients.Client)
-	if err := client.Read(); err != nil {
+	client, err := clients.Load(c.store, tks.ServiceIdentity)
+	if err != nil {
 		ui.Debug("%+v", err)
-		return nil
+		if strict {
+			return nil, errors.Wrap(err, "failed reading client credentials needed to revoke tokens")
+		}
+		c.deleteSession(tks)
+		return nil, nil
 	}
 
-	serverConn, err := grpcutil.Connection(tks.Issuer, "lift-auth", client.ClientId, client.ClientSecret)
+	config := new(discovery.ProviderConfig)
+	configErr := config.Read(c.store)
+	if open != nil && configErr != nil {
+		return nil, configErr
+	}
+
+	// Missing exchanged tokens only means none were exchanged.
+	exchanged, _ := tokens.ReadExchanged(c.store)
+
+	// Tokens are revoked before ending any session, so that with strict, nothing is signed out
+	// unless all of them were revoked.
+	results := revokeTokens(ctx, config, configErr, tks, exchanged, client)
+	if strict {
+		for _, result := range results {
+			if result.Err != nil {
+				return results, errors.Wrapf(result.Err, "failed revoking %s", result.TokenType)
+			}
+		}
+	}
+
+	// Tokens are kept if the browser session could not be ended, so that signing out can be retried.
+	// Revoking them again succeeds, even if they were already revoked.
+	if open != nil {
+		if err := endBrowserSession(ctx, config, tks, client, open); err != nil {
+			return results, err
+		}
+	}
+
+	// The connection to the identity server authenticates with client credentials, so it does not
+	// matter that tokens were revoked already.
+	endSession(ctx, c.store, tks, client)
+
+	c.deleteSession(tks)
+	return results, nil
+}
+
+// deleteSession removes tokens from the store, along with the credentials of service identities.
+func (c *Client) deleteSession(tks *tokens.Tokens) {
+	tokens.Delete(c.store)
+	if tks.ServiceIdentity {
+		clients.DeleteService(c.store)
+	}
+}
+
+// revokeTokens revokes refresh and access tokens using the provider's revocation endpoint.
+// The refresh token is revoked first since, with most providers, it also invalidates the
+// access tokens issued with it. Refresh tokens issued along with exchanged tokens are revoked
+// too, since they outlive the session otherwise. configErr is the error reading provider
+// configuration, if any, it is reported for every token.
+func revokeTokens(ctx context.Context, config *discovery.ProviderConfig, configErr error, tks *tokens.Tokens, exchanged []*tokens.ExchangedToken, client *clients.Client) []TokenRevocation {
+	var results []TokenRevocation
+	revoke := func(token, hint, audience string) {
+		if token == "" {
+			return
+		}
+
+		err := configErr
+		if err == nil {
+			err = tokens.Revoke(ctx, config.RevocationEndpoint, client.ClientId, client.ClientSecret, token, hint)
+		}
+
+		if err != nil {
+			ui.Debug("%+v", errors.Wrapf(err, "failed revoking %s", hint))
+		}
+		results = append(results, TokenRevocation{TokenType: hint, Audience: audience, Err: err})
+	}
+
+	revoke(tks.Refresh, "refresh_token", "")
+	revoke(tks.Access, "access_token", "")
+	for _, t := range exchanged {
+		revoke(t.Refresh, "refresh_token", t.Audience)
+	}
+	return results
+}
+
+// endSession signs the user out from the identity server. Errors are only logged.
+func endSession(ctx context.Context, s store.Store, tks *tokens.Tokens, client *clients.Client) {
+	serverConn, err := grpcutil.Dial(ctx, s, tks.Issuer, "lift-auth", grpcutil.WithBasicAuth(client.ClientId, client.ClientSecret))
 	if err != nil {
-		// We were unable to revoke tokens in the server, so we just return
-		// and let them expire.
-		ui.Debug("%+v", errors.Wrap(err, "we were unable to revoke tokens in the server"))
-		return nil
+		// We were unable to sign out from the server, so we just return
+		// and let tokens expire.
+		ui.Debug("%+v", errors.Wrap(err, "we were unable to sign out from the server"))
+		return
 	}
 	defer serverConn.Close()
 
 	authzClient := api.NewAuthzClient(serverConn)
-	ctx := context.Background()
 
 	if _, err := authzClient.SignOut(ctx, &api.SignOutRequest{
 		IdToken: tks.ID,
 	}); err != nil {
 		ui.Debug("%+v", errors.Wrap(err, "failed signing user out from identity server"))
 	}
+}
+
+// endBrowserSession ends the user's session with the provider by sending the browser to its end
+// session endpoint, and waits for the provider to redirect it back to the post logout redirect URI.
+func endBrowserSession(ctx context.Context, config *discovery.ProviderConfig, tks *tokens.Tokens, client *clients.Client, open func(logoutURL string) error) error {
+	if config.EndSessionEndpoint == "" {
+		return fmt.Errorf("%q does not advertise an end session endpoint", config.Issuer)
+	}
+
+	state, err := randomValue()
+	if err != nil {
+		return errors.Wrap(err, "failed getting random value for logout state")
+	}
+
+	server, err := loopback.Listen(openidc.RedirectURI, state)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+
+	logoutURL, err := url.Parse(config.EndSessionEndpoint)
+	if err != nil {
+		return errors.Wrapf(err, "failed parsing end session endpoint %q", config.EndSessionEndpoint)
+	}
+
+	query := logoutURL.Query()
+	query.Set("id_token_hint", tks.ID)
+	query.Set("client_id", client.ClientId)
+	query.Set("post_logout_redirect_uri", openidc.RedirectURI)
+	query.Set("state", state)
+	logoutURL.RawQuery = query.Encode()
+
+	if err := open(logoutURL.String()); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, browserTimeout)
+	defer cancel()
+
+	params, err := server.Wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	if params.Get("state") != state {
+		return errors.New("logout state received does not match value sent")
+	}
+
+	if errCode := params.Get("error"); errCode != "" {
+		return &tokens.ProviderError{
+			Code:        errCode,
+			Description: params.Get("error_description"),
+			URI:         params.Get("error_uri"),
+		}
+	}
 	return nil
 }
@@ -0,0 +1,268 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/lift-plugins/auth/openidc"
+	"github.com/lift-plugins/auth/openidc/clients"
+	"github.com/lift-plugins/auth/openidc/discovery"
+	"github.com/lift-plugins/auth/openidc/tokens"
+)
+
+// userSession stores a user session with provider p.
+func userSession(t *testing.T, p *testProvider) {
+	config := &discovery.ProviderConfig{Issuer: p.URL, RevocationEndpoint: p.URL + "/revoke"}
+	if err := config.Write(p.store); err != nil {
+		t.Fatal(err)
+	}
+
+	client := new(clients.Client)
+	client.ClientId = "lift-cli"
+	client.ClientSecret = "secret"
+	if err := client.Write(p.store); err != nil {
+		t.Fatal(err)
+	}
+
+	tks := &tokens.Tokens{Issuer: p.URL, Access: "access", Refresh: "refresh"}
+	if err := tks.Write(p.store); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignOutStrict(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+	userSession(t, p)
+
+	var (
+		mu      sync.Mutex
+		fail    = true
+		revoked []string
+	)
+	p.mux.HandleFunc("/revoke", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if user, _, _ := r.BasicAuth(); user != "lift-cli" {
+			t.Errorf("expected client credentials, got %q", user)
+		}
+
+		if fail && r.PostFormValue("token_type_hint") == "refresh_token" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		revoked = append(revoked, r.PostFormValue("token"))
+	})
+
+	c := p.client()
+	results, err := c.SignOut(context.Background(), true)
+	if err == nil {
+		t.Fatal("expected an error when a token could not be revoked")
+	}
+
+	if len(results) != 2 || results[0].TokenType != "refresh_token" || results[0].Err == nil || results[1].Err != nil {
+		t.Errorf("unexpected revocation results: %+v", results)
+	}
+
+	if err := new(tokens.Tokens).Read(p.store); err != nil {
+		t.Errorf("expected tokens to be kept so that signing out can be retried: %v", err)
+	}
+
+	mu.Lock()
+	fail = false
+	revoked = nil
+	mu.Unlock()
+
+	if _, err := c.SignOut(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(revoked) != 2 || revoked[0] != "refresh" || revoked[1] != "access" {
+		t.Errorf("expected refresh and access tokens to be revoked in order, got %v", revoked)
+	}
+
+	if err := new(tokens.Tokens).Read(p.store); err == nil {
+		t.Error("expected tokens to be removed")
+	}
+}
+
+func TestSignOutNotStrict(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+	userSession(t, p)
+
+	p.mux.HandleFunc("/revoke", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	results, err := p.client().SignOut(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, result := range results {
+		if result.Err == nil {
+			t.Errorf("expected %s revocation to fail", result.TokenType)
+		}
+	}
+
+	if err := new(tokens.Tokens).Read(p.store); err == nil {
+		t.Error("expected tokens to be removed even if they could not be revoked")
+	}
+}
+
+// redirect sends the browser back to the post logout redirect URI with the given parameters,
+// returning the status code of the response.
+func redirect(t *testing.T, params url.Values) int {
+	resp, err := http.Get(openidc.RedirectURI + "?" + params.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestEndBrowserSession(t *testing.T) {
+	config := &discovery.ProviderConfig{Issuer: "https://id.example.com", EndSessionEndpoint: "https://id.example.com/logout?ui=compact"}
+	tks := &tokens.Tokens{ID: "id-token"}
+	client := new(clients.Client)
+	client.ClientId = "lift-cli"
+
+	open := func(logoutURL string) error {
+		u, err := url.Parse(logoutURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if u.Host != "id.example.com" || u.Path != "/logout" {
+			t.Errorf("expected the end session endpoint, got %q", logoutURL)
+		}
+
+		query := u.Query()
+		expected := map[string]string{
+			"ui":                       "compact",
+			"id_token_hint":            "id-token",
+			"client_id":                "lift-cli",
+			"post_logout_redirect_uri": openidc.RedirectURI,
+		}
+		for param, value := range expected {
+			if query.Get(param) != value {
+				t.Errorf("expected %s to be %q, got %q", param, value, query.Get(param))
+			}
+		}
+
+		state := query.Get("state")
+		if state == "" {
+			t.Fatal("expected state in the logout URL")
+		}
+
+		if status := redirect(t, url.Values{"state": {"forged"}}); status != http.StatusBadRequest {
+			t.Errorf("expected redirects with a mismatching state to be rejected, got status %d", status)
+		}
+
+		redirect(t, url.Values{"state": {state}})
+		return nil
+	}
+
+	if err := endBrowserSession(context.Background(), config, tks, client, open); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEndBrowserSessionProviderError(t *testing.T) {
+	config := &discovery.ProviderConfig{Issuer: "https://id.example.com", EndSessionEndpoint: "https://id.example.com/logout"}
+	open := func(logoutURL string) error {
+		u, err := url.Parse(logoutURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		redirect(t, url.Values{"state": {u.Query().Get("state")}, "error": {"access_denied"}})
+		return nil
+	}
+
+	err := endBrowserSession(context.Background(), config, new(tokens.Tokens), new(clients.Client), open)
+	if perr, ok := err.(*tokens.ProviderError); !ok || perr.Code != "access_denied" {
+		t.Errorf("expected provider error, got %#v", err)
+	}
+}
+
+func TestEndBrowserSessionUnsupported(t *testing.T) {
+	open := func(string) error {
+		t.Error("the browser should not be opened")
+		return nil
+	}
+
+	config := &discovery.ProviderConfig{Issuer: "https://id.example.com"}
+	if err := endBrowserSession(context.Background(), config, new(tokens.Tokens), new(clients.Client), open); err == nil {
+		t.Error("expected an error without an end session endpoint")
+	}
+}
+
+func TestSignOutRevokesExchangedTokens(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+	userSession(t, p)
+
+	exchanged := map[string]interface{}{"tokens": map[string]interface{}{
+		"api read": map[string]interface{}{"access": "api-access", "refresh": "api-refresh", "audience": "api"},
+		"billing":  map[string]interface{}{"access": "billing-access", "audience": "billing"},
+	}}
+	if err := p.store.Write("exchanged.json", exchanged); err != nil {
+		t.Fatal(err)
+	}
+
+	var revoked []string
+	p.mux.HandleFunc("/revoke", func(w http.ResponseWriter, r *http.Request) {
+		revoked = append(revoked, r.PostFormValue("token"))
+	})
+
+	results, err := p.client().SignOut(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(revoked) != 3 || revoked[2] != "api-refresh" {
+		t.Errorf("expected the exchanged refresh token to be revoked, got %v", revoked)
+	}
+
+	if len(results) != 3 || results[2].Audience != "api" {
+		t.Errorf("unexpected revocation results: %+v", results)
+	}
+
+	if err := p.store.Read("exchanged.json", &exchanged); err == nil {
+		t.Error("expected exchanged tokens to be removed")
+	}
+}
+
+func TestSignOutGloballyStrict(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+	userSession(t, p)
+
+	config := &discovery.ProviderConfig{Issuer: p.URL, RevocationEndpoint: p.URL + "/revoke", EndSessionEndpoint: p.URL + "/logout"}
+	if err := config.Write(p.store); err != nil {
+		t.Fatal(err)
+	}
+
+	p.mux.HandleFunc("/revoke", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	open := func(string) error {
+		t.Error("the browser session should not be ended before tokens are revoked")
+		return nil
+	}
+
+	if _, err := p.client().SignOutGlobally(context.Background(), true, open); err == nil {
+		t.Fatal("expected an error when tokens could not be revoked")
+	}
+
+	if err := new(tokens.Tokens).Read(p.store); err != nil {
+		t.Errorf("expected tokens to be kept so that signing out can be retried: %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"context"
+)
+
+// ExchangeToken returns an access token of the current session narrowed down to a single audience
+// and a subset of scopes, so that services only receive the privileges they need. Tokens are cached
+// per audience and scopes until they expire.
+func ExchangeToken(audience string, scope []string) (string, error) {
+	return NewClient().ExchangeToken(context.Background(), audience, scope)
+}
+
+// ExchangeToken returns an access token narrowed down to audience and scope. See ExchangeToken
+// function for details.
+func (c *Client) ExchangeToken(ctx context.Context, audience string, scope []string) (string, error) {
+	ctx, err := c.bind(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	tks, client, err := c.session()
+	if err != nil {
+		return "", err
+	}
+
+	exchanged, err := tks.Exchange(ctx, c.store, client.ClientId, client.ClientSecret, audience, scope)
+	if err != nil {
+		return "", err
+	}
+
+	return exchanged.Access, nil
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/lift-plugins/auth/openidc/tokens"
+)
+
+// TokenSource returns an oauth2.TokenSource backed by the current Lift session. Tokens are
+// refreshed as they expire.
+func TokenSource() oauth2.TokenSource {
+	return NewClient().TokenSource(context.Background())
+}
+
+// TokenSource returns an oauth2.TokenSource backed by the session managed by c. Requests to
+// the provider are bound to ctx. Tokens are reused until they expire, without reading the store
+// again.
+func (c *Client) TokenSource(ctx context.Context) oauth2.TokenSource {
+	return oauth2.ReuseTokenSource(nil, &tokenSource{ctx: ctx, client: c})
+}
+
+type tokenSource struct {
+	ctx    context.Context
+	client *Client
+}
+
+func (s *tokenSource) Token() (*oauth2.Token, error) {
+	tks, err := s.client.validTokens(s.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oauth2.Token{
+		AccessToken: tks.Access,
+		TokenType:   "Bearer",
+		Expiry:      expiry(tks),
+	}, nil
+}
+
+// expiry returns when the access token expires, or the zero time if unknown.
+func expiry(tks *tokens.Tokens) time.Time {
+	if tks.ExpiresAt != 0 {
+		return time.Unix(tks.ExpiresAt, 0)
+	}
+
+	accessToken, err := tokens.Decode(tks.Access)
+	if err != nil || accessToken.Expires == 0 {
+		return time.Time{}
+	}
+	return time.Unix(accessToken.Expires, 0)
+}
+
+// Transport is an http.RoundTripper that authenticates requests with the access token of a Lift
+// session. If the server rejects the token with 401 Unauthorized, tokens are refreshed and the
+// request is retried once, as long as its body can be sent again.
+//
+// The HTTP client given to the session Client through WithHTTPClient must not use this transport, since
+// requests refreshing tokens would then require tokens themselves.
+type Transport struct {
+	// Client is the session used to authenticate requests. Defaults to the active profile session.
+	Client *Client
+	// Base is the transport used to send requests. Defaults to http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+// RoundTrip sends the request with a Bearer authorization header.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c := t.Client
+	if c == nil {
+		c = NewClient()
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	accessToken, err := c.Token(req.Context())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting access token")
+	}
+
+	resp, err := base.RoundTrip(authorize(req, accessToken))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	retry := authorize(req, "")
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+
+	newToken, err := c.refreshRejected(req.Context(), accessToken)
+	if err != nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	retry.Header.Set("Authorization", "Bearer "+newToken)
+	return base.RoundTrip(retry)
+}
+
+// authorize returns a copy of req with a Bearer authorization header. Requests must not be
+// modified by RoundTrippers, so headers are copied too.
+func authorize(req *http.Request, accessToken string) *http.Request {
+	r := req.WithContext(req.Context())
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+
+	if accessToken != "" {
+		r.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return r
+}
+
+// refreshRejected refreshes tokens after the server rejected the given access token, returning the
+// new access token. Tokens are not refreshed again if another request already did.
+func (c *Client) refreshRejected(ctx context.Context, rejected string) (string, error) {
+	ctx, err := c.bind(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	tks, client, err := c.session()
+	if err != nil {
+		return "", err
+	}
+
+	if tks.Access == rejected {
+		if err := tks.ForceRefresh(ctx, c.store, client.ClientId, client.ClientSecret); err != nil {
+			return "", err
+		}
+	}
+	return tks.Access, nil
+}
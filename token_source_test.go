@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lift-plugins/auth/openidc/tokens"
+)
+
+// countingStore counts reads of stored tokens.
+type countingStore struct {
+	*memStore
+	mu    sync.Mutex
+	reads int
+}
+
+func (s *countingStore) Read(name string, v interface{}) error {
+	if name == "tokens.json" {
+		s.mu.Lock()
+		s.reads++
+		s.mu.Unlock()
+	}
+	return s.memStore.Read(name, v)
+}
+
+// apiSession stores a service session whose access token is still valid, and returns a Transport
+// for it along with the number of requests received by the token endpoint.
+func apiSession(t *testing.T, access string) (*memStore, *Transport, *int, func()) {
+	var refreshes int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshes++
+		fmt.Fprint(w, `{"access_token": "fresh", "token_type": "Bearer", "expires_in": 3600}`)
+	}))
+
+	mem := newMemStore()
+	serviceSession(t, mem, srv.URL)
+	writeAccess(t, mem, access)
+
+	return mem, &Transport{Client: NewClient(WithStore(mem))}, &refreshes, srv.Close
+}
+
+// writeAccess stores service tokens with the given access token, valid for an hour.
+func writeAccess(t *testing.T, mem *memStore, access string) {
+	tks := new(tokens.Tokens)
+	if err := tks.Read(mem); err != nil {
+		t.Fatal(err)
+	}
+
+	tks.Access = access
+	tks.ExpiresAt = time.Now().Add(time.Hour).Unix()
+	if err := tks.Write(mem); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// apiServer returns a server accepting only the fresh access token, recording the bodies received.
+func apiServer(t *testing.T, bodies *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		*bodies = append(*bodies, string(body))
+
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+}
+
+func TestTransportRetriesRejectedToken(t *testing.T) {
+	_, transport, refreshes, closeProvider := apiSession(t, "stale")
+	defer closeProvider()
+
+	var bodies []string
+	srv := apiServer(t, &bodies)
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected request to be retried with refreshed tokens, got status %d", resp.StatusCode)
+	}
+
+	if *refreshes != 1 {
+		t.Errorf("expected tokens to be refreshed once, got %d", *refreshes)
+	}
+
+	if len(bodies) != 2 || bodies[1] != "payload" {
+		t.Errorf("expected the body to be sent again, got %q", bodies)
+	}
+
+	if req.Header.Get("Authorization") != "" {
+		t.Error("the original request should not be modified")
+	}
+}
+
+func TestTransportBodyNotReplayable(t *testing.T) {
+	_, transport, refreshes, closeProvider := apiSession(t, "stale")
+	defer closeProvider()
+
+	var bodies []string
+	srv := apiServer(t, &bodies)
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL, ioutil.NopCloser(strings.NewReader("payload")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected the rejection to be returned, got status %d", resp.StatusCode)
+	}
+
+	if *refreshes != 0 || len(bodies) != 1 {
+		t.Errorf("expected no refresh nor retry, got %d refreshes and %d requests", *refreshes, len(bodies))
+	}
+}
+
+func TestTransportTokenRefreshedByAnotherRequest(t *testing.T) {
+	mem, transport, refreshes, closeProvider := apiSession(t, "stale")
+	defer closeProvider()
+
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			// Another request refreshes tokens while this one is rejected.
+			writeAccess(t, mem, "fresh")
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || requests != 2 {
+		t.Errorf("expected request to be retried with the stored token, got status %d after %d requests", resp.StatusCode, requests)
+	}
+
+	if *refreshes != 0 {
+		t.Errorf("expected tokens refreshed by another request to be used, got %d refreshes", *refreshes)
+	}
+}
+
+func TestTokenSourceReusesTokens(t *testing.T) {
+	mem := newMemStore()
+	serviceSession(t, mem, "http://127.0.0.1:1")
+	writeAccess(t, mem, "valid")
+
+	s := &countingStore{memStore: mem}
+	ts := NewClient(WithStore(s)).TokenSource(context.Background())
+	for i := 0; i < 3; i++ {
+		token, err := ts.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if token.AccessToken != "valid" {
+			t.Errorf("unexpected access token %q", token.AccessToken)
+		}
+	}
+
+	if s.reads != 1 {
+		t.Errorf("expected tokens to be read once while valid, got %d reads", s.reads)
+	}
+}
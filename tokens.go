@@ -1,13 +1,113 @@
 package auth
 
-import "github.com/lift-plugins/auth/openidc/tokens"
+import (
+	"context"
+
+	"github.com/lift-plugins/auth/openidc/discovery"
+	"github.com/lift-plugins/auth/openidc/tokens"
+)
+
+// TokenIntrospection is the provider's view of a token of the current session.
+type TokenIntrospection struct {
+	// TokenType is either "refresh_token" or "access_token".
+	TokenType string
+	*tokens.Introspection
+}
 
 // Tokens returns the ID Token and Access token for the current user session.
 func Tokens() (string, string, error) {
+	return NewClient().Tokens(context.Background())
+}
+
+// Tokens returns the stored ID Token and Access token, as they are. Use Token to get an access
+// token that did not expire.
+func (c *Client) Tokens(ctx context.Context) (string, string, error) {
+	if _, err := c.bind(ctx); err != nil {
+		return "", "", err
+	}
+
 	tks := new(tokens.Tokens)
-	if err := tks.Read(); err != nil {
+	if err := tks.Read(c.store); err != nil {
 		return "", "", err
 	}
 
 	return tks.ID, tks.Access, nil
 }
+
+// TokenClaims returns the claims of the ID and Access tokens for the current session, decoded
+// locally. ID token is nil for service identities, and so is the access token if it is opaque.
+func TokenClaims() (*tokens.JSONWebToken, *tokens.JSONWebToken, error) {
+	return NewClient().TokenClaims(context.Background())
+}
+
+// TokenClaims returns the claims of the stored tokens. See TokenClaims function for details.
+func (c *Client) TokenClaims(ctx context.Context) (*tokens.JSONWebToken, *tokens.JSONWebToken, error) {
+	if _, err := c.bind(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	tks := new(tokens.Tokens)
+	if err := tks.Read(c.store); err != nil {
+		return nil, nil, err
+	}
+
+	var idToken, accessToken *tokens.JSONWebToken
+	var err error
+	if tks.ID != "" {
+		if idToken, err = tokens.Decode(tks.ID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if !tks.ServiceIdentity {
+		if accessToken, err = tokens.Decode(tks.Access); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		accessToken, _ = tokens.Decode(tks.Access)
+	}
+
+	return idToken, accessToken, nil
+}
+
+// IntrospectTokens asks the provider whether the access and refresh tokens of the current session
+// are still active.
+func IntrospectTokens() ([]TokenIntrospection, error) {
+	return NewClient().IntrospectTokens(context.Background())
+}
+
+// IntrospectTokens asks the provider whether the session tokens are still active.
+func (c *Client) IntrospectTokens(ctx context.Context) ([]TokenIntrospection, error) {
+	ctx, err := c.bind(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tks, client, err := c.session()
+	if err != nil {
+		return nil, err
+	}
+
+	config := new(discovery.ProviderConfig)
+	if err := config.Read(c.store); err != nil {
+		return nil, err
+	}
+
+	var results []TokenIntrospection
+	for _, t := range []struct{ token, hint string }{
+		{tks.Access, "access_token"},
+		{tks.Refresh, "refresh_token"},
+	} {
+		if t.token == "" {
+			continue
+		}
+
+		introspection, err := tokens.Introspect(ctx, config.IntrospectionEndpoint, client.ClientId, client.ClientSecret, t.token, t.hint)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, TokenIntrospection{TokenType: t.hint, Introspection: introspection})
+	}
+
+	return results, nil
+}
@@ -1,17 +1,57 @@
 package auth
 
 import (
+	"context"
+	"time"
+
+	"github.com/lift-plugins/auth/openidc/clients"
+	"github.com/lift-plugins/auth/openidc/discovery"
 	"github.com/lift-plugins/auth/openidc/tokens"
 )
 
-// WhoAmI returns the email of the current logged user.
+// Identity describes the current session, combining tokens with the claims returned by the
+// provider's UserInfo endpoint.
+type Identity struct {
+	Subject       string
+	Issuer        string
+	Name          string
+	Email         string
+	EmailVerified bool
+	Scopes        []string
+	// ExpiresAt is when the current access token expires. Sessions are extended by refreshing tokens.
+	ExpiresAt time.Time
+	// ServiceIdentity is true when signed in with client credentials. Name holds the client ID.
+	ServiceIdentity bool
+}
+
+// WhoAmI returns the email of the current logged user, or the client ID if signed in
+// as a service identity.
 func WhoAmI() (string, error) {
+	return NewClient().WhoAmI(context.Background())
+}
+
+// WhoAmI returns the email of the current logged user, or the client ID if signed in
+// as a service identity.
+func (c *Client) WhoAmI(ctx context.Context) (string, error) {
+	ctx, err := c.bind(ctx)
+	if err != nil {
+		return "", err
+	}
+
 	tks := new(tokens.Tokens)
-	if err := tks.Read(); err != nil {
+	if err := tks.Read(c.store); err != nil {
 		return "", err
 	}
 
-	if _, err := tokens.Verify(tks.ID); err != nil {
+	if tks.ServiceIdentity {
+		client, err := clients.Load(c.store, true)
+		if err != nil {
+			return "", err
+		}
+		return client.ClientId, nil
+	}
+
+	if _, err := tokens.Verify(ctx, c.store, tks.ID); err != nil {
 		return "", err
 	}
 
@@ -22,3 +62,68 @@ func WhoAmI() (string, error) {
 
 	return token.Email, nil
 }
+
+// CurrentIdentity returns details about the current session. User claims are requested from the
+// provider's UserInfo endpoint, refreshing tokens first if they expired.
+func CurrentIdentity() (*Identity, error) {
+	return NewClient().Identity(context.Background())
+}
+
+// Identity returns details about the current session. See CurrentIdentity for details.
+func (c *Client) Identity(ctx context.Context) (*Identity, error) {
+	ctx, err := c.bind(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tks, client, err := c.session()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tks.RefreshToken(ctx, c.store, client.ClientId, client.ClientSecret); err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		Issuer:          tks.Issuer,
+		ServiceIdentity: tks.ServiceIdentity,
+	}
+
+	// Service identities may be issued opaque access tokens.
+	if accessToken, err := tokens.Decode(tks.Access); err == nil {
+		identity.Subject = accessToken.Subject
+		identity.Scopes = accessToken.Scope
+		if accessToken.Expires != 0 {
+			identity.ExpiresAt = time.Unix(accessToken.Expires, 0)
+		}
+	}
+
+	if tks.ServiceIdentity {
+		identity.Name = client.ClientId
+		if tks.ExpiresAt != 0 {
+			identity.ExpiresAt = time.Unix(tks.ExpiresAt, 0)
+		}
+		return identity, nil
+	}
+
+	if _, err := tokens.Verify(ctx, c.store, tks.ID); err != nil {
+		return nil, err
+	}
+
+	config := new(discovery.ProviderConfig)
+	if err := config.Read(c.store); err != nil {
+		return nil, err
+	}
+
+	info, err := tks.UserInfo(ctx, c.store, client.ClientId, config.UserInfoEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	identity.Subject = info.Subject
+	identity.Name = info.Name
+	identity.Email = info.Email
+	identity.EmailVerified = info.EmailVerified
+	return identity, nil
+}